@@ -0,0 +1,104 @@
+package main
+
+import (
+	"chirpy/internal/auth"
+	"chirpy/internal/database"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+
+	"github.com/google/uuid"
+)
+
+// deactivateHandler hides the authenticated user's account until they
+// reactivate it. Their chirps are kept but drop out of public listings, and
+// every refresh token is revoked so other sessions end.
+func (cfg *apiConfig) deactivateHandler(w http.ResponseWriter, r *http.Request) {
+	tokenString, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithErrorCode(w, http.StatusUnauthorized, codeMissingToken, "Couldn't find JWT")
+		return
+	}
+
+	userID, err := cfg.JWTKeys.ValidateJWT(tokenString)
+	if err != nil {
+		respondWithErrorCode(w, http.StatusUnauthorized, codeInvalidToken, "Invalid JWT")
+		return
+	}
+
+	var dbUser database.User
+	err = cfg.withTx(r.Context(), func(q *database.Queries) error {
+		var txErr error
+		dbUser, txErr = q.SetUserActive(r.Context(), database.SetUserActiveParams{
+			ID:     userID,
+			Active: false,
+		})
+		if txErr != nil {
+			return txErr
+		}
+		return q.RevokeRefreshTokensForUser(r.Context(), userID)
+	})
+	cfg.UserCache.Invalidate(userID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			respondWithErrorCode(w, http.StatusUnauthorized, codeUserDeleted, "User no longer exists")
+			return
+		}
+		respondWithErrorCode(w, http.StatusInternalServerError, codeInternalError, "Failed to deactivate account")
+		return
+	}
+
+	w.Header().Set("ETag", userETag(dbUser.Version))
+	respondWithJSON(w, http.StatusOK, databaseUserToUser(dbUser))
+}
+
+// reactivateHandler restores a deactivated account. A still-valid JWT is
+// enough; otherwise the caller sends their email and password, since login
+// refuses deactivated accounts and so can't be used to get a JWT.
+func (cfg *apiConfig) reactivateHandler(w http.ResponseWriter, r *http.Request) {
+	var userID uuid.UUID
+	if tokenString, err := auth.GetBearerToken(r.Header); err == nil {
+		userID, err = cfg.JWTKeys.ValidateJWT(tokenString)
+		if err != nil {
+			respondWithErrorCode(w, http.StatusUnauthorized, codeInvalidToken, "Invalid JWT")
+			return
+		}
+	} else {
+		if !requireJSONContentType(w, r) {
+			return
+		}
+
+		decoder := json.NewDecoder(r.Body)
+		var reqBody loginBody
+
+		err = decoder.Decode(&reqBody)
+		if err != nil {
+			respondWithErrorCode(w, http.StatusBadRequest, codeInvalidPayload, "Invalid request payload")
+			return
+		}
+
+		// Shares login's lockout so this can't be used to keep guessing passwords
+		dbUser, ok := cfg.checkCredentials(w, r, reqBody.Email, reqBody.Password)
+		if !ok {
+			return
+		}
+		userID = dbUser.ID
+	}
+
+	dbUser, err := cfg.DB.SetUserActive(r.Context(), database.SetUserActiveParams{
+		ID:     userID,
+		Active: true,
+	})
+	cfg.UserCache.Invalidate(userID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			respondWithErrorCode(w, http.StatusUnauthorized, codeUserDeleted, "User no longer exists")
+			return
+		}
+		respondWithErrorCode(w, http.StatusInternalServerError, codeInternalError, "Failed to reactivate account")
+		return
+	}
+
+	w.Header().Set("ETag", userETag(dbUser.Version))
+	respondWithJSON(w, http.StatusOK, databaseUserToUser(dbUser))
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"chirpy/internal/database"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	// defaultActivityRange is how far back activity goes when 'from' is omitted.
+	defaultActivityRange = 30 * 24 * time.Hour
+	// maxActivityRange bounds the zero-filled response to about a year of days.
+	maxActivityRange = 366 * 24 * time.Hour
+)
+
+// DayActivity is the number of chirps posted on one UTC day.
+type DayActivity struct {
+	Date  string `json:"date"`
+	Count int64  `json:"count"`
+}
+
+// getChirpActivityHandler returns per-day chirp counts over [from, to),
+// optionally for one author, with every day in the range present.
+func (cfg *apiConfig) getChirpActivityHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	to := time.Now().UTC()
+	if toStr := query.Get("to"); toStr != "" {
+		parsed, err := time.Parse(time.RFC3339, toStr)
+		if err != nil {
+			respondWithErrorCode(w, http.StatusBadRequest, codeInvalidParameter, "Invalid 'to', must be an RFC3339 timestamp")
+			return
+		}
+		to = parsed.UTC()
+	}
+
+	from := to.Add(-defaultActivityRange)
+	if fromStr := query.Get("from"); fromStr != "" {
+		parsed, err := time.Parse(time.RFC3339, fromStr)
+		if err != nil {
+			respondWithErrorCode(w, http.StatusBadRequest, codeInvalidParameter, "Invalid 'from', must be an RFC3339 timestamp")
+			return
+		}
+		from = parsed.UTC()
+	}
+
+	if !from.Before(to) {
+		respondWithErrorCode(w, http.StatusBadRequest, codeInvalidParameter, "'from' must be before 'to'")
+		return
+	}
+	if to.Sub(from) > maxActivityRange {
+		respondWithErrorCode(w, http.StatusBadRequest, codeInvalidParameter, "Date range must be at most 366 days")
+		return
+	}
+
+	var authorID uuid.NullUUID
+	if authorIDStr := query.Get("author_id"); authorIDStr != "" {
+		parsed, err := uuid.Parse(authorIDStr)
+		if err != nil {
+			respondWithErrorCode(w, http.StatusBadRequest, codeInvalidID, "Invalid author ID")
+			return
+		}
+		authorID = uuid.NullUUID{UUID: parsed, Valid: true}
+	}
+
+	rows, err := retryRead(r.Context(), func() ([]database.GetChirpActivityRow, error) {
+		return cfg.DB.GetChirpActivity(r.Context(), database.GetChirpActivityParams{
+			FromTime: from,
+			ToTime:   to,
+			UserID:   authorID,
+		})
+	})
+	if err != nil {
+		respondWithErrorCode(w, http.StatusInternalServerError, codeInternalError, "Failed to retrieve chirp activity")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, zeroFillActivity(from, to, rows))
+}
+
+// zeroFillActivity expands the per-day counts into one entry for every UTC
+// day touched by [from, to), using zero for days with no chirps, so charts
+// don't have to fill gaps themselves.
+func zeroFillActivity(from, to time.Time, rows []database.GetChirpActivityRow) []DayActivity {
+	counts := make(map[string]int64, len(rows))
+	for _, row := range rows {
+		counts[row.Day.Format(time.DateOnly)] = row.ChirpCount
+	}
+
+	activity := []DayActivity{}
+	for day := from.Truncate(24 * time.Hour); day.Before(to); day = day.AddDate(0, 0, 1) {
+		date := day.Format(time.DateOnly)
+		activity = append(activity, DayActivity{Date: date, Count: counts[date]})
+	}
+
+	return activity
+}
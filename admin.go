@@ -0,0 +1,294 @@
+package main
+
+import (
+	"chirpy/internal/auth"
+	"chirpy/internal/database"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"github.com/google/uuid"
+)
+
+const (
+	defaultPageLimit = 20
+	maxPageLimit     = 100
+)
+
+var errInvalidAdminKey = errors.New("invalid admin API key")
+
+// authorizeAdmin checks the ApiKey authorization header against the configured admin key.
+func (cfg *apiConfig) authorizeAdmin(headers http.Header) error {
+	apiKey, err := auth.GetAPIKey(headers)
+	if err != nil {
+		return err
+	}
+
+	// An unset admin key disables admin access entirely
+	if cfg.AdminKey == "" || subtle.ConstantTimeCompare([]byte(apiKey), []byte(cfg.AdminKey)) != 1 {
+		return errInvalidAdminKey
+	}
+
+	return nil
+}
+
+// usersPage represents a paginated list of users returned to admins.
+type usersPage struct {
+	Users  []User `json:"users"`
+	Total  int64  `json:"total"`
+	Limit  int32  `json:"limit"`
+	Offset int32  `json:"offset"`
+}
+
+// parsePagination reads the optional 'limit' and 'offset' query parameters.
+func parsePagination(r *http.Request) (limit, offset int32, err error) {
+	limit = defaultPageLimit
+
+	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
+		n, convErr := strconv.Atoi(limitStr)
+		if convErr != nil || n < 1 || n > maxPageLimit {
+			return 0, 0, errors.New("limit must be between 1 and " + strconv.Itoa(maxPageLimit))
+		}
+		limit = int32(n)
+	}
+
+	if offsetStr := r.URL.Query().Get("offset"); offsetStr != "" {
+		n, convErr := strconv.Atoi(offsetStr)
+		if convErr != nil || n < 0 {
+			return 0, 0, errors.New("offset must be a non-negative integer")
+		}
+		offset = int32(n)
+	}
+
+	return limit, offset, nil
+}
+
+// adminUsersHandler lists users page by page for moderation.
+func (cfg *apiConfig) adminUsersHandler(w http.ResponseWriter, r *http.Request) {
+	err := cfg.authorizeAdmin(r.Header)
+	if err != nil {
+		respondWithErrorCode(w, http.StatusUnauthorized, codeInvalidAPIKey, "Invalid or missing admin API key")
+		return
+	}
+
+	limit, offset, err := parsePagination(r)
+	if err != nil {
+		respondWithErrorCode(w, http.StatusBadRequest, codeInvalidParameter, err.Error())
+		return
+	}
+
+	dbUsers, err := cfg.DB.GetUsersPaginated(r.Context(), database.GetUsersPaginatedParams{
+		Limit:  limit,
+		Offset: offset,
+	})
+	if err != nil {
+		respondWithErrorCode(w, http.StatusInternalServerError, codeInternalError, "Failed to retrieve users")
+		return
+	}
+
+	total, err := cfg.DB.CountUsers(r.Context())
+	if err != nil {
+		respondWithErrorCode(w, http.StatusInternalServerError, codeInternalError, "Failed to count users")
+		return
+	}
+
+	// Map to the public User struct so password hashes never leave the server
+	users := []User{}
+	for _, dbUser := range dbUsers {
+		users = append(users, databaseUserToUser(dbUser))
+	}
+
+	respondWithJSON(w, http.StatusOK, usersPage{
+		Users:  users,
+		Total:  total,
+		Limit:  limit,
+		Offset: offset,
+	})
+}
+
+// adminDeleteChirpHandler deletes any chirp by ID, regardless of who owns it.
+func (cfg *apiConfig) adminDeleteChirpHandler(w http.ResponseWriter, r *http.Request) {
+	err := cfg.authorizeAdmin(r.Header)
+	if err != nil {
+		respondWithErrorCode(w, http.StatusUnauthorized, codeInvalidAPIKey, "Invalid or missing admin API key")
+		return
+	}
+
+	chirpIDStr := r.PathValue("chirpID")
+	chirpID, err := uuid.Parse(chirpIDStr)
+	if err != nil {
+		respondWithErrorCode(w, http.StatusBadRequest, codeInvalidID, "Invalid chirp ID")
+		return
+	}
+
+	dbChirp, err := cfg.DB.GetChirpForDeletion(r.Context(), chirpID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			respondWithErrorCode(w, http.StatusNotFound, codeChirpNotFound, "Chirp not found")
+			return
+		}
+		respondWithErrorCode(w, http.StatusInternalServerError, codeInternalError, "Failed to retrieve chirp")
+		return
+	}
+
+	// Archive open reports so the chirp leaves the moderation queue
+	err = cfg.withTx(r.Context(), func(q *database.Queries) error {
+		txErr := q.UnpinDeletedChirp(r.Context(), uuid.NullUUID{UUID: chirpID, Valid: true})
+		if txErr != nil {
+			return txErr
+		}
+		txErr = q.DeleteChirpByID(r.Context(), chirpID)
+		if txErr != nil {
+			return txErr
+		}
+		return q.ArchiveChirpReports(r.Context(), chirpID)
+	})
+	// Only the author can have pinned it, so theirs is the only cached user that changed
+	cfg.UserCache.Invalidate(dbChirp.UserID)
+	if err != nil {
+		respondWithErrorCode(w, http.StatusInternalServerError, codeInternalError, "Failed to delete chirp")
+		return
+	}
+
+	// Keep an audit trail of moderator deletions
+	slog.Info("Admin deleted chirp",
+		"request_id", requestIDFromContext(r.Context()),
+		"chirp_id", dbChirp.ID,
+		"user_id", dbChirp.UserID,
+		"remote_addr", r.RemoteAddr,
+	)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// adminChirpyRedBody represents the expected JSON request body for setting
+// a user's Chirpy Red status.
+type adminChirpyRedBody struct {
+	IsChirpyRed *bool `json:"is_chirpy_red"`
+}
+
+// adminSetChirpyRedHandler lets support staff grant or remove Chirpy Red
+// directly, for comps and billing disputes, without going through Polka.
+func (cfg *apiConfig) adminSetChirpyRedHandler(w http.ResponseWriter, r *http.Request) {
+	err := cfg.authorizeAdmin(r.Header)
+	if err != nil {
+		respondWithErrorCode(w, http.StatusUnauthorized, codeInvalidAPIKey, "Invalid or missing admin API key")
+		return
+	}
+
+	userID, err := uuid.Parse(r.PathValue("userID"))
+	if err != nil {
+		respondWithErrorCode(w, http.StatusBadRequest, codeInvalidID, "Invalid user ID")
+		return
+	}
+
+	if !requireJSONContentType(w, r) {
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	var reqBody adminChirpyRedBody
+
+	err = decoder.Decode(&reqBody)
+	if err != nil {
+		respondWithErrorCode(w, http.StatusBadRequest, codeInvalidPayload, "is_chirpy_red must be a boolean")
+		return
+	}
+	if reqBody.IsChirpyRed == nil {
+		respondWithErrorCode(w, http.StatusUnprocessableEntity, codeInvalidPayload, "is_chirpy_red is required")
+		return
+	}
+
+	var dbUser database.User
+	if *reqBody.IsChirpyRed {
+		dbUser, err = cfg.DB.UpdateUserIsChirpyRed(r.Context(), userID)
+	} else {
+		dbUser, err = cfg.DB.UpdateUserIsChirpyRedFalse(r.Context(), userID)
+	}
+	cfg.UserCache.Invalidate(userID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			respondWithErrorCode(w, http.StatusNotFound, codeUserNotFound, "User not found")
+			return
+		}
+		respondWithErrorCode(w, http.StatusInternalServerError, codeInternalError, "Failed to update user")
+		return
+	}
+
+	slog.Info("Admin set Chirpy Red status",
+		"request_id", requestIDFromContext(r.Context()),
+		"user_id", userID,
+		"is_chirpy_red", *reqBody.IsChirpyRed,
+		"remote_addr", r.RemoteAddr,
+	)
+
+	w.Header().Set("ETag", userETag(dbUser.Version))
+	respondWithJSON(w, http.StatusOK, databaseUserToUser(dbUser))
+}
+
+// adminVerifiedBody represents the expected JSON request body for setting
+// a user's verified status.
+type adminVerifiedBody struct {
+	IsVerified *bool `json:"is_verified"`
+}
+
+// adminSetVerifiedHandler marks or unmarks an account as verified. Verification
+// is only ever changed here, so Chirpy Red changes never touch it.
+func (cfg *apiConfig) adminSetVerifiedHandler(w http.ResponseWriter, r *http.Request) {
+	err := cfg.authorizeAdmin(r.Header)
+	if err != nil {
+		respondWithErrorCode(w, http.StatusUnauthorized, codeInvalidAPIKey, "Invalid or missing admin API key")
+		return
+	}
+
+	userID, err := uuid.Parse(r.PathValue("userID"))
+	if err != nil {
+		respondWithErrorCode(w, http.StatusBadRequest, codeInvalidID, "Invalid user ID")
+		return
+	}
+
+	if !requireJSONContentType(w, r) {
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	var reqBody adminVerifiedBody
+
+	err = decoder.Decode(&reqBody)
+	if err != nil {
+		respondWithErrorCode(w, http.StatusBadRequest, codeInvalidPayload, "is_verified must be a boolean")
+		return
+	}
+	if reqBody.IsVerified == nil {
+		respondWithErrorCode(w, http.StatusUnprocessableEntity, codeInvalidPayload, "is_verified is required")
+		return
+	}
+
+	dbUser, err := cfg.DB.SetUserVerified(r.Context(), database.SetUserVerifiedParams{
+		ID:         userID,
+		IsVerified: *reqBody.IsVerified,
+	})
+	cfg.UserCache.Invalidate(userID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			respondWithErrorCode(w, http.StatusNotFound, codeUserNotFound, "User not found")
+			return
+		}
+		respondWithErrorCode(w, http.StatusInternalServerError, codeInternalError, "Failed to update user")
+		return
+	}
+
+	slog.Info("Admin set verified status",
+		"request_id", requestIDFromContext(r.Context()),
+		"user_id", userID,
+		"is_verified", *reqBody.IsVerified,
+		"remote_addr", r.RemoteAddr,
+	)
+
+	w.Header().Set("ETag", userETag(dbUser.Version))
+	respondWithJSON(w, http.StatusOK, databaseUserToUser(dbUser))
+}
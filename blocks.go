@@ -0,0 +1,183 @@
+package main
+
+import (
+	"chirpy/internal/auth"
+	"chirpy/internal/database"
+	"context"
+	"database/sql"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// blockUserHandler lets the authenticated user block another user. Blocking
+// hides each user's chirps from the other; blocking twice is a no-op.
+func (cfg *apiConfig) blockUserHandler(w http.ResponseWriter, r *http.Request) {
+	blockerID, blockedID, ok := cfg.parseBlockRequest(w, r)
+	if !ok {
+		return
+	}
+
+	_, err := cfg.DB.GetUserByID(r.Context(), blockedID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			respondWithErrorCode(w, http.StatusNotFound, codeUserNotFound, "User not found")
+			return
+		}
+		respondWithErrorCode(w, http.StatusInternalServerError, codeInternalError, "Failed to retrieve user")
+		return
+	}
+
+	err = cfg.DB.CreateUserBlock(r.Context(), database.CreateUserBlockParams{
+		BlockerID: blockerID,
+		BlockedID: blockedID,
+		CreatedAt: time.Now().UTC(),
+	})
+	if err != nil {
+		respondWithErrorCode(w, http.StatusInternalServerError, codeInternalError, "Failed to block user")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// unblockUserHandler removes a block the authenticated user placed.
+func (cfg *apiConfig) unblockUserHandler(w http.ResponseWriter, r *http.Request) {
+	blockerID, blockedID, ok := cfg.parseBlockRequest(w, r)
+	if !ok {
+		return
+	}
+
+	deleted, err := cfg.DB.DeleteUserBlock(r.Context(), database.DeleteUserBlockParams{
+		BlockerID: blockerID,
+		BlockedID: blockedID,
+	})
+	if err != nil {
+		respondWithErrorCode(w, http.StatusInternalServerError, codeInternalError, "Failed to unblock user")
+		return
+	}
+	if deleted == 0 {
+		respondWithErrorCode(w, http.StatusNotFound, codeUserNotFound, "You have not blocked this user")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// parseBlockRequest authenticates the caller and reads the target user from
+// the path. It writes an error response and returns false on failure.
+func (cfg *apiConfig) parseBlockRequest(w http.ResponseWriter, r *http.Request) (blockerID, blockedID uuid.UUID, ok bool) {
+	tokenString, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithErrorCode(w, http.StatusUnauthorized, codeMissingToken, "Couldn't find JWT")
+		return uuid.Nil, uuid.Nil, false
+	}
+
+	blockerID, err = cfg.JWTKeys.ValidateJWT(tokenString)
+	if err != nil {
+		respondWithErrorCode(w, http.StatusUnauthorized, codeInvalidToken, "Invalid JWT")
+		return uuid.Nil, uuid.Nil, false
+	}
+
+	blockedID, err = uuid.Parse(r.PathValue("userID"))
+	if err != nil {
+		respondWithErrorCode(w, http.StatusBadRequest, codeInvalidID, "Invalid user ID")
+		return uuid.Nil, uuid.Nil, false
+	}
+
+	if blockedID == blockerID {
+		respondWithErrorCode(w, http.StatusBadRequest, codeInvalidParameter, "You cannot block yourself")
+		return uuid.Nil, uuid.Nil, false
+	}
+
+	return blockerID, blockedID, true
+}
+
+// chirpViewer is who a chirp listing or feed is being built for.
+type chirpViewer struct {
+	// id is the signed-in caller, and is null for anonymous requests
+	id uuid.NullUUID
+	// hidden holds the users on either side of a block with the caller
+	hidden map[uuid.UUID]bool
+}
+
+// canSee reports whether the viewer may read a chirp by authorID: a block
+// hides it either way, and a private chirp is shown only to its author.
+func (v chirpViewer) canSee(authorID uuid.UUID, visibility database.ChirpVisibility) bool {
+	if v.hidden[authorID] {
+		return false
+	}
+	return visibility == database.ChirpVisibilityPublic || (v.id.Valid && v.id.UUID == authorID)
+}
+
+// authorViewer is the viewer for chirps the author just wrote, who can read
+// their own private chirps and quotes.
+func authorViewer(userID uuid.UUID) chirpViewer {
+	return chirpViewer{id: uuid.NullUUID{UUID: userID, Valid: true}}
+}
+
+// requestViewer identifies the caller from an optional bearer token and loads
+// the users whose chirps a block hides from them. Anonymous callers see only
+// public chirps, and hide nothing. It writes an error response and returns
+// false on failure.
+func (cfg *apiConfig) requestViewer(w http.ResponseWriter, r *http.Request) (chirpViewer, bool) {
+	if r.Header.Get("Authorization") == "" {
+		return chirpViewer{}, true
+	}
+
+	tokenString, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithErrorCode(w, http.StatusUnauthorized, codeMissingToken, "Couldn't find JWT")
+		return chirpViewer{}, false
+	}
+
+	viewerID, err := cfg.JWTKeys.ValidateJWT(tokenString)
+	if err != nil {
+		respondWithErrorCode(w, http.StatusUnauthorized, codeInvalidToken, "Invalid JWT")
+		return chirpViewer{}, false
+	}
+
+	hidden, err := cfg.blockRelatedUsers(r.Context(), viewerID)
+	if err != nil {
+		respondWithErrorCode(w, http.StatusInternalServerError, codeInternalError, "Failed to retrieve blocked users")
+		return chirpViewer{}, false
+	}
+	return chirpViewer{id: uuid.NullUUID{UUID: viewerID, Valid: true}, hidden: hidden}, true
+}
+
+// blockRelatedUsers returns the users on either side of a block with viewerID.
+func (cfg *apiConfig) blockRelatedUsers(ctx context.Context, viewerID uuid.UUID) (map[uuid.UUID]bool, error) {
+	userIDs, err := retryRead(ctx, func() ([]uuid.UUID, error) {
+		return cfg.DB.GetBlockRelatedUserIDs(ctx, viewerID)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	hidden := make(map[uuid.UUID]bool, len(userIDs))
+	for _, userID := range userIDs {
+		hidden[userID] = true
+	}
+	return hidden, nil
+}
+
+// hiddenAuthorIDs lists the hidden users, for queries that exclude them in SQL.
+func hiddenAuthorIDs(hidden map[uuid.UUID]bool) []uuid.UUID {
+	userIDs := []uuid.UUID{}
+	for userID := range hidden {
+		userIDs = append(userIDs, userID)
+	}
+	return userIDs
+}
+
+// visibleChirps drops the chirps the viewer can't see.
+func visibleChirps(chirps []Chirp, viewer chirpViewer) []Chirp {
+	visible := chirps[:0]
+	for _, chirp := range chirps {
+		if viewer.canSee(chirp.UserID, chirp.Visibility) {
+			visible = append(visible, chirp)
+		}
+	}
+	return visible
+}
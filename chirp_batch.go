@@ -0,0 +1,212 @@
+package main
+
+import (
+	"chirpy/internal/auth"
+	"chirpy/internal/database"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	// maxChirpBatchSize caps how many chirps a single batch request may create.
+	maxChirpBatchSize = 50
+	// maxBulkGetSize caps how many chirps or users a single bulk-get request may fetch.
+	maxBulkGetSize = 100
+)
+
+// createChirpsBatchHandler creates several chirps for the authenticated user in one transaction.
+func (cfg *apiConfig) createChirpsBatchHandler(w http.ResponseWriter, r *http.Request) {
+	// 1. Get and validate JWT once for the whole batch
+	tokenString, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithErrorCode(w, http.StatusUnauthorized, codeMissingToken, "Couldn't find JWT")
+		return
+	}
+
+	userID, err := cfg.JWTKeys.ValidateJWT(tokenString)
+	if err != nil {
+		respondWithErrorCode(w, http.StatusUnauthorized, codeInvalidToken, "Invalid JWT")
+		return
+	}
+
+	// 2. Decode the request body (an array of chirps)
+	if !requireJSONContentType(w, r) {
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	var reqBody []createChirpBody
+
+	err = decoder.Decode(&reqBody)
+	if err != nil {
+		respondWithErrorCode(w, http.StatusBadRequest, codeInvalidPayload, "Invalid request payload")
+		return
+	}
+
+	if len(reqBody) == 0 {
+		respondWithErrorCode(w, http.StatusUnprocessableEntity, codeInvalidPayload, "Batch must contain at least one chirp")
+		return
+	}
+
+	if len(reqBody) > maxChirpBatchSize {
+		respondWithErrorCode(w, http.StatusUnprocessableEntity, codeBatchTooLarge, fmt.Sprintf("Batch cannot contain more than %d chirps", maxChirpBatchSize))
+		return
+	}
+
+	// 3. Validate everything up front so one bad chirp rejects the whole batch
+	cleanedBodies := make([]string, len(reqBody))
+	visibilities := make([]database.ChirpVisibility, len(reqBody))
+	for i, chirpBody := range reqBody {
+		cleanedBodies[i], err = validateChirp(chirpBody.Body, cfg.MaxChirpLength, cfg.chirpCensorReplacement(), cfg.ProfanityMatch)
+		if err == nil {
+			visibilities[i], err = parseChirpVisibility(chirpBody.Visibility)
+		}
+		if err != nil {
+			respondWithErrorCode(w, http.StatusUnprocessableEntity, chirpErrorCode(err), fmt.Sprintf("Chirp at index %d is invalid: %s", i, err))
+			return
+		}
+	}
+
+	// The JWT may outlive the account it was issued for
+	dbUser, err := cfg.DB.GetUserByID(r.Context(), userID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			respondWithErrorCode(w, http.StatusUnauthorized, codeUserDeleted, "User no longer exists")
+			return
+		}
+		respondWithErrorCode(w, http.StatusInternalServerError, codeInternalError, "Failed to retrieve user")
+		return
+	}
+	if !dbUser.Active {
+		respondWithErrorCode(w, http.StatusForbidden, codeAccountDeactivated, "Account deactivated")
+		return
+	}
+
+	quotedIDs := []uuid.UUID{}
+	for _, chirpBody := range reqBody {
+		if chirpBody.QuotedChirpID != nil {
+			quotedIDs = append(quotedIDs, *chirpBody.QuotedChirpID)
+		}
+	}
+	if !cfg.checkQuotedChirps(w, r, quotedIDs) {
+		return
+	}
+
+	// Every chirp in the batch counts toward the hourly limit
+	allowed, retryAfter, err := cfg.checkChirpRateLimit(r.Context(), dbUser, len(cleanedBodies))
+	if err != nil {
+		respondWithErrorCode(w, http.StatusInternalServerError, codeInternalError, "Failed to check chirp rate limit")
+		return
+	}
+	if !allowed {
+		respondWithRateLimited(w, retryAfter)
+		return
+	}
+
+	// 4. Insert all chirps in a single transaction
+	dbChirps := make([]database.Chirp, 0, len(cleanedBodies))
+	err = cfg.withTx(r.Context(), func(q *database.Queries) error {
+		for i, cleanedBody := range cleanedBodies {
+			now := time.Now().UTC()
+			var quotedChirpID uuid.NullUUID
+			if reqBody[i].QuotedChirpID != nil {
+				quotedChirpID = uuid.NullUUID{UUID: *reqBody[i].QuotedChirpID, Valid: true}
+			}
+			dbChirp, txErr := createChirpWithHashtags(r.Context(), q, database.CreateChirpParams{
+				ID:            newID(),
+				CreatedAt:     now,
+				UpdatedAt:     now,
+				Body:          cleanedBody,
+				UserID:        userID,
+				QuotedChirpID: quotedChirpID,
+				Visibility:    visibilities[i],
+			})
+			if txErr != nil {
+				return txErr
+			}
+			dbChirps = append(dbChirps, dbChirp)
+		}
+		return nil
+	})
+	if err != nil {
+		respondWithErrorCode(w, http.StatusInternalServerError, codeInternalError, "Failed to create chirps")
+		return
+	}
+
+	chirps := []Chirp{}
+	for _, dbChirp := range dbChirps {
+		chirps = append(chirps, databaseChirpToChirp(dbChirp))
+	}
+
+	cfg.flagProfanity(chirps)
+	err = cfg.embedQuotedChirps(r.Context(), chirps, authorViewer(userID))
+	if err != nil {
+		// The chirps are already saved, so send them without quote summaries
+		slog.Warn("Failed to embed quoted chirps", "request_id", requestIDFromContext(r.Context()), "error", err)
+	}
+
+	// Publish only once the whole batch has committed
+	for _, chirp := range chirps {
+		cfg.publishChirp(r.Context(), chirp)
+	}
+
+	respondWithJSON(w, http.StatusCreated, chirps)
+}
+
+// bulkGetChirpsHandler returns the chirps matching a JSON array of IDs in one query.
+// IDs with no matching chirp are skipped rather than failing the request.
+func (cfg *apiConfig) bulkGetChirpsHandler(w http.ResponseWriter, r *http.Request) {
+	if !requireJSONContentType(w, r) {
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	var chirpIDs []uuid.UUID
+
+	err := decoder.Decode(&chirpIDs)
+	if err != nil {
+		respondWithErrorCode(w, http.StatusBadRequest, codeInvalidPayload, "Request body must be an array of chirp IDs")
+		return
+	}
+
+	if len(chirpIDs) > maxBulkGetSize {
+		respondWithErrorCode(w, http.StatusUnprocessableEntity, codeBatchTooLarge, fmt.Sprintf("Cannot fetch more than %d chirps at once", maxBulkGetSize))
+		return
+	}
+
+	viewer, ok := cfg.requestViewer(w, r)
+	if !ok {
+		return
+	}
+
+	dbChirps, err := retryRead(r.Context(), func() ([]database.Chirp, error) {
+		return cfg.DB.GetChirpsByIDs(r.Context(), database.GetChirpsByIDsParams{Ids: chirpIDs, ViewerID: viewer.id})
+	})
+	if err != nil {
+		respondWithErrorCode(w, http.StatusInternalServerError, codeInternalError, "Failed to retrieve chirps")
+		return
+	}
+
+	chirps := []Chirp{}
+	for _, dbChirp := range dbChirps {
+		chirps = append(chirps, databaseChirpToChirp(dbChirp))
+	}
+
+	chirps = visibleChirps(chirps, viewer)
+
+	err = cfg.embedQuotedChirps(r.Context(), chirps, viewer)
+	if err != nil {
+		respondWithErrorCode(w, http.StatusInternalServerError, codeInternalError, "Failed to retrieve quoted chirps")
+		return
+	}
+
+	cfg.flagProfanity(chirps)
+
+	respondWithJSON(w, http.StatusOK, chirps)
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+var errInvalidCursor = errors.New("invalid cursor")
+
+// chirpsPage represents one page of chirps returned with cursor pagination.
+// NextCursor is null once there are no more chirps to fetch.
+type chirpsPage struct {
+	// Chirps is a []Chirp, or only the selected keys of each with ?fields=
+	Chirps     any     `json:"chirps"`
+	NextCursor *string `json:"next_cursor"`
+}
+
+// chirpCursor marks the last chirp a client has seen. The id breaks ties
+// between chirps that share a created_at.
+type chirpCursor struct {
+	CreatedAt time.Time
+	ID        uuid.UUID
+}
+
+// encodeChirpCursor returns an opaque cursor pointing just past the given chirp.
+func encodeChirpCursor(createdAt time.Time, id uuid.UUID) string {
+	raw := createdAt.UTC().Format(time.RFC3339Nano) + "|" + id.String()
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+// decodeChirpCursor parses a cursor produced by encodeChirpCursor.
+func decodeChirpCursor(s string) (chirpCursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return chirpCursor{}, errInvalidCursor
+	}
+
+	createdAtStr, idStr, found := strings.Cut(string(raw), "|")
+	if !found {
+		return chirpCursor{}, errInvalidCursor
+	}
+
+	createdAt, err := time.Parse(time.RFC3339Nano, createdAtStr)
+	if err != nil {
+		return chirpCursor{}, errInvalidCursor
+	}
+
+	id, err := uuid.Parse(idStr)
+	if err != nil {
+		return chirpCursor{}, errInvalidCursor
+	}
+
+	return chirpCursor{CreatedAt: createdAt, ID: id}, nil
+}
+
+// parseCursorPagination reads the optional 'limit' and 'cursor' query parameters.
+// A nil cursor means start from the first page.
+func parseCursorPagination(r *http.Request) (limit int32, cursor *chirpCursor, err error) {
+	limit = defaultPageLimit
+
+	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
+		n, convErr := strconv.Atoi(limitStr)
+		if convErr != nil || n < 1 || n > maxPageLimit {
+			return 0, nil, errors.New("limit must be between 1 and " + strconv.Itoa(maxPageLimit))
+		}
+		limit = int32(n)
+	}
+
+	if cursorStr := r.URL.Query().Get("cursor"); cursorStr != "" {
+		c, decodeErr := decodeChirpCursor(cursorStr)
+		if decodeErr != nil {
+			return 0, nil, decodeErr
+		}
+		cursor = &c
+	}
+
+	return limit, cursor, nil
+}
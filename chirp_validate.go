@@ -0,0 +1,46 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"unicode/utf8"
+)
+
+// chirpValidation is the result of checking a chirp body without posting it.
+type chirpValidation struct {
+	Valid bool `json:"valid"`
+	// RuneCount is the length the limit applies to: the trimmed body, before
+	// profanity is replaced
+	RuneCount   int    `json:"rune_count"`
+	CleanedBody string `json:"cleaned_body"`
+	Error       string `json:"error,omitempty"`
+}
+
+// validateChirpHandler runs a chirp body through the same trimming, length,
+// and profanity rules as createChirpHandler without storing anything, so
+// compose boxes can show a counter that agrees with the server.
+func (cfg *apiConfig) validateChirpHandler(w http.ResponseWriter, r *http.Request) {
+	reqBody, ok := decodeCreateChirpBody(w, r)
+	if !ok {
+		return
+	}
+
+	replacement := cfg.chirpCensorReplacement()
+	trimmed := strings.TrimSpace(reqBody.Body)
+	result := chirpValidation{
+		Valid:       true,
+		RuneCount:   utf8.RuneCountInString(trimmed),
+		CleanedBody: trimmed,
+	}
+	if replacement != "" {
+		result.CleanedBody = sanitizeChirp(trimmed, replacement, cfg.ProfanityMatch)
+	}
+
+	_, err := validateChirp(reqBody.Body, cfg.MaxChirpLength, replacement, cfg.ProfanityMatch)
+	if err != nil {
+		result.Valid = false
+		result.Error = err.Error()
+	}
+
+	respondWithJSON(w, http.StatusOK, result)
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"chirpy/internal/auth"
+	"net/http"
+	"time"
+)
+
+// debugTokenClaims is what GET /api/debug/token reports about a JWT.
+type debugTokenClaims struct {
+	Issuer    string     `json:"issuer"`
+	Subject   string     `json:"subject"`
+	Audience  []string   `json:"audience,omitempty"`
+	KeyID     string     `json:"kid,omitempty"`
+	IssuedAt  *time.Time `json:"issued_at"`
+	ExpiresAt *time.Time `json:"expires_at"`
+	Expired   bool       `json:"expired"`
+	// Valid is whether the server would accept the token; ValidationError says why not
+	Valid           bool   `json:"valid"`
+	ValidationError string `json:"validation_error,omitempty"`
+}
+
+// debugTokenHandler decodes the bearer token's claims, even when it has
+// expired or fails validation, to help diagnose auth problems. It is only
+// routed on the dev platform and refuses to run anywhere else.
+func (cfg *apiConfig) debugTokenHandler(w http.ResponseWriter, r *http.Request) {
+	// Answer like an unrouted path so production doesn't reveal the endpoint
+	if cfg.Platform != "dev" {
+		http.NotFound(w, r)
+		return
+	}
+
+	tokenString, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithErrorCode(w, http.StatusUnauthorized, codeMissingToken, "Couldn't find JWT")
+		return
+	}
+
+	claims, kid, err := auth.DecodeJWT(tokenString)
+	if err != nil {
+		respondWithErrorCode(w, http.StatusBadRequest, codeInvalidToken, "Malformed JWT: "+err.Error())
+		return
+	}
+
+	resp := debugTokenClaims{
+		Issuer:   claims.Issuer,
+		Subject:  claims.Subject,
+		Audience: claims.Audience,
+		KeyID:    kid,
+	}
+	if claims.IssuedAt != nil {
+		resp.IssuedAt = &claims.IssuedAt.Time
+	}
+	if claims.ExpiresAt != nil {
+		resp.ExpiresAt = &claims.ExpiresAt.Time
+		resp.Expired = time.Now().After(claims.ExpiresAt.Time)
+	}
+
+	_, err = cfg.JWTKeys.ValidateJWT(tokenString)
+	resp.Valid = err == nil
+	if err != nil {
+		resp.ValidationError = err.Error()
+	}
+
+	respondWithJSON(w, http.StatusOK, resp)
+}
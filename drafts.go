@@ -0,0 +1,307 @@
+package main
+
+import (
+	"chirpy/internal/auth"
+	"chirpy/internal/database"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+)
+
+// maxDraftLength bounds stored drafts, counted in runes. Drafts may run past
+// the chirp limit while being written, but not without limit.
+const maxDraftLength = 10000
+
+// draftBody represents the expected JSON request body for creating or editing a draft.
+type draftBody struct {
+	Body string `json:"body"`
+}
+
+// Draft is an unpublished chirp body belonging to the authenticated user.
+type Draft struct {
+	ID        uuid.UUID `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	Body      string    `json:"body"`
+	UserID    uuid.UUID `json:"user_id"`
+}
+
+func databaseDraftToDraft(dbDraft database.Draft) Draft {
+	return Draft{
+		ID:        dbDraft.ID,
+		CreatedAt: dbDraft.CreatedAt,
+		UpdatedAt: dbDraft.UpdatedAt,
+		Body:      dbDraft.Body,
+		UserID:    dbDraft.UserID,
+	}
+}
+
+// authenticateDraftRequest checks the JWT and, when the route has one, parses
+// the draft ID. It writes an error response and returns false on failure.
+func (cfg *apiConfig) authenticateDraftRequest(w http.ResponseWriter, r *http.Request) (userID, draftID uuid.UUID, ok bool) {
+	tokenString, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithErrorCode(w, http.StatusUnauthorized, codeMissingToken, "Couldn't find JWT")
+		return uuid.Nil, uuid.Nil, false
+	}
+
+	userID, err = cfg.JWTKeys.ValidateJWT(tokenString)
+	if err != nil {
+		respondWithErrorCode(w, http.StatusUnauthorized, codeInvalidToken, "Invalid JWT")
+		return uuid.Nil, uuid.Nil, false
+	}
+
+	if draftIDStr := r.PathValue("draftID"); draftIDStr != "" {
+		draftID, err = uuid.Parse(draftIDStr)
+		if err != nil {
+			respondWithErrorCode(w, http.StatusBadRequest, codeInvalidID, "Invalid draft ID")
+			return uuid.Nil, uuid.Nil, false
+		}
+	}
+
+	return userID, draftID, true
+}
+
+// decodeDraftBody reads a draft from a JSON body. Only the draft size limit
+// applies here; chirp validation waits until the draft is published.
+func decodeDraftBody(w http.ResponseWriter, r *http.Request) (draftBody, bool) {
+	if !requireJSONContentType(w, r) {
+		return draftBody{}, false
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	var reqBody draftBody
+
+	err := decoder.Decode(&reqBody)
+	if err != nil {
+		respondWithErrorCode(w, http.StatusBadRequest, codeInvalidPayload, "Invalid request payload")
+		return draftBody{}, false
+	}
+
+	if utf8.RuneCountInString(reqBody.Body) > maxDraftLength {
+		respondWithErrorCode(w, http.StatusUnprocessableEntity, codeDraftTooLong, "Draft is too long")
+		return draftBody{}, false
+	}
+
+	return reqBody, true
+}
+
+// createDraftHandler saves a new draft for the authenticated user.
+func (cfg *apiConfig) createDraftHandler(w http.ResponseWriter, r *http.Request) {
+	userID, _, ok := cfg.authenticateDraftRequest(w, r)
+	if !ok {
+		return
+	}
+
+	reqBody, ok := decodeDraftBody(w, r)
+	if !ok {
+		return
+	}
+
+	now := time.Now().UTC()
+	dbDraft, err := cfg.DB.CreateDraft(r.Context(), database.CreateDraftParams{
+		ID:        newID(),
+		CreatedAt: now,
+		UpdatedAt: now,
+		Body:      reqBody.Body,
+		UserID:    userID,
+	})
+	if err != nil {
+		respondWithErrorCode(w, http.StatusInternalServerError, codeInternalError, "Failed to create draft")
+		return
+	}
+
+	w.Header().Set("Location", "/api/drafts/"+dbDraft.ID.String())
+	respondWithJSON(w, http.StatusCreated, databaseDraftToDraft(dbDraft))
+}
+
+// getDraftsHandler lists the authenticated user's drafts, most recently edited first.
+func (cfg *apiConfig) getDraftsHandler(w http.ResponseWriter, r *http.Request) {
+	userID, _, ok := cfg.authenticateDraftRequest(w, r)
+	if !ok {
+		return
+	}
+
+	dbDrafts, err := retryRead(r.Context(), func() ([]database.Draft, error) {
+		return cfg.DB.GetDraftsByUser(r.Context(), userID)
+	})
+	if err != nil {
+		respondWithErrorCode(w, http.StatusInternalServerError, codeInternalError, "Failed to retrieve drafts")
+		return
+	}
+
+	drafts := []Draft{}
+	for _, dbDraft := range dbDrafts {
+		drafts = append(drafts, databaseDraftToDraft(dbDraft))
+	}
+
+	respondWithJSON(w, http.StatusOK, drafts)
+}
+
+// getDraftHandler returns one of the authenticated user's drafts.
+func (cfg *apiConfig) getDraftHandler(w http.ResponseWriter, r *http.Request) {
+	userID, draftID, ok := cfg.authenticateDraftRequest(w, r)
+	if !ok {
+		return
+	}
+
+	dbDraft, err := retryRead(r.Context(), func() (database.Draft, error) {
+		return cfg.DB.GetDraft(r.Context(), database.GetDraftParams{ID: draftID, UserID: userID})
+	})
+	if err != nil {
+		if err == sql.ErrNoRows {
+			respondWithErrorCode(w, http.StatusNotFound, codeDraftNotFound, "Draft not found")
+			return
+		}
+		respondWithErrorCode(w, http.StatusInternalServerError, codeInternalError, "Failed to retrieve draft")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, databaseDraftToDraft(dbDraft))
+}
+
+// updateDraftHandler replaces the body of one of the authenticated user's drafts.
+func (cfg *apiConfig) updateDraftHandler(w http.ResponseWriter, r *http.Request) {
+	userID, draftID, ok := cfg.authenticateDraftRequest(w, r)
+	if !ok {
+		return
+	}
+
+	reqBody, ok := decodeDraftBody(w, r)
+	if !ok {
+		return
+	}
+
+	dbDraft, err := cfg.DB.UpdateDraft(r.Context(), database.UpdateDraftParams{
+		ID:        draftID,
+		UserID:    userID,
+		Body:      reqBody.Body,
+		UpdatedAt: time.Now().UTC(),
+	})
+	if err != nil {
+		if err == sql.ErrNoRows {
+			respondWithErrorCode(w, http.StatusNotFound, codeDraftNotFound, "Draft not found")
+			return
+		}
+		respondWithErrorCode(w, http.StatusInternalServerError, codeInternalError, "Failed to update draft")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, databaseDraftToDraft(dbDraft))
+}
+
+// deleteDraftHandler discards one of the authenticated user's drafts.
+func (cfg *apiConfig) deleteDraftHandler(w http.ResponseWriter, r *http.Request) {
+	userID, draftID, ok := cfg.authenticateDraftRequest(w, r)
+	if !ok {
+		return
+	}
+
+	deleted, err := cfg.DB.DeleteDraft(r.Context(), database.DeleteDraftParams{ID: draftID, UserID: userID})
+	if err != nil {
+		respondWithErrorCode(w, http.StatusInternalServerError, codeInternalError, "Failed to delete draft")
+		return
+	}
+	if deleted == 0 {
+		respondWithErrorCode(w, http.StatusNotFound, codeDraftNotFound, "Draft not found")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// publishDraftHandler turns a draft into a chirp. The draft must pass the
+// same validation, account, and rate limit checks as a new chirp, and is
+// deleted in the same transaction so it can only be published once.
+func (cfg *apiConfig) publishDraftHandler(w http.ResponseWriter, r *http.Request) {
+	userID, draftID, ok := cfg.authenticateDraftRequest(w, r)
+	if !ok {
+		return
+	}
+
+	dbDraft, err := cfg.DB.GetDraft(r.Context(), database.GetDraftParams{ID: draftID, UserID: userID})
+	if err != nil {
+		if err == sql.ErrNoRows {
+			respondWithErrorCode(w, http.StatusNotFound, codeDraftNotFound, "Draft not found")
+			return
+		}
+		respondWithErrorCode(w, http.StatusInternalServerError, codeInternalError, "Failed to retrieve draft")
+		return
+	}
+
+	cleanedBody, err := validateChirp(dbDraft.Body, cfg.MaxChirpLength, cfg.chirpCensorReplacement(), cfg.ProfanityMatch)
+	if err != nil {
+		respondWithErrorCode(w, http.StatusUnprocessableEntity, chirpErrorCode(err), err.Error())
+		return
+	}
+
+	// The JWT may outlive the account it was issued for
+	dbUser, err := cfg.DB.GetUserByID(r.Context(), userID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			respondWithErrorCode(w, http.StatusUnauthorized, codeUserDeleted, "User no longer exists")
+			return
+		}
+		respondWithErrorCode(w, http.StatusInternalServerError, codeInternalError, "Failed to retrieve user")
+		return
+	}
+	if !dbUser.Active {
+		respondWithErrorCode(w, http.StatusForbidden, codeAccountDeactivated, "Account deactivated")
+		return
+	}
+
+	allowed, retryAfter, err := cfg.checkChirpRateLimit(r.Context(), dbUser, 1)
+	if err != nil {
+		respondWithErrorCode(w, http.StatusInternalServerError, codeInternalError, "Failed to check chirp rate limit")
+		return
+	}
+	if !allowed {
+		respondWithRateLimited(w, retryAfter)
+		return
+	}
+
+	now := time.Now().UTC()
+
+	var dbChirp database.Chirp
+	err = cfg.withTx(r.Context(), func(q *database.Queries) error {
+		deleted, txErr := q.DeleteDraft(r.Context(), database.DeleteDraftParams{ID: draftID, UserID: userID})
+		if txErr != nil {
+			return txErr
+		}
+		// A concurrent publish or delete got here first
+		if deleted == 0 {
+			return sql.ErrNoRows
+		}
+
+		dbChirp, txErr = createChirpWithHashtags(r.Context(), q, database.CreateChirpParams{
+			ID:         newID(),
+			CreatedAt:  now,
+			UpdatedAt:  now,
+			Body:       cleanedBody,
+			UserID:     userID,
+			Visibility: database.ChirpVisibilityPublic,
+		})
+		return txErr
+	})
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			respondWithErrorCode(w, http.StatusNotFound, codeDraftNotFound, "Draft not found")
+			return
+		}
+		respondWithErrorCode(w, http.StatusInternalServerError, codeInternalError, "Failed to publish draft")
+		return
+	}
+
+	chirp := databaseChirpToChirp(dbChirp)
+	chirp.ContainsProfanity = cfg.ProfanityMode == profanityFlag && containsProfanity(chirp.Body, cfg.ProfanityMatch)
+
+	cfg.publishChirp(r.Context(), chirp)
+
+	w.Header().Set("Location", chirpLocation(chirp.ID))
+	respondWithJSON(w, http.StatusCreated, chirp)
+}
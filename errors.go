@@ -0,0 +1,70 @@
+package main
+
+import "errors"
+
+// Stable, machine-readable error codes returned alongside human-readable messages.
+// Clients should branch on these rather than on message text, which may change.
+//
+// A request body that can't be parsed at all, like malformed JSON, or a bad
+// path or query parameter, is a 400. A body that parses but breaks a rule,
+// like a chirp over the length limit or a missing required field, is a 422
+// Unprocessable Entity, so clients can tell what to fix: the encoding or the
+// content.
+const (
+	codeInvalidPayload       = "invalid_payload"
+	codeUnsupportedMediaType = "unsupported_media_type"
+	codeInvalidParameter     = "invalid_parameter"
+	codeInvalidID            = "invalid_id"
+	codeMissingToken         = "missing_token"
+	codeInvalidToken         = "invalid_token"
+	codeInvalidCredentials   = "invalid_credentials"
+	codeInvalidAPIKey        = "invalid_api_key"
+	codeUserDeleted          = "user_deleted"
+	codeAccountDeactivated   = "account_deactivated"
+	codeForbidden            = "forbidden"
+	codeChirpNotFound        = "chirp_not_found"
+	codeUserNotFound         = "user_not_found"
+	codeInvalidAvatarURL     = "invalid_avatar_url"
+	codeBioTooLong           = "bio_too_long"
+	codeChirpTooLong         = "chirp_too_long"
+	codeChirpEmpty           = "chirp_empty"
+	codeInvalidVisibility    = "invalid_visibility"
+	codeDraftNotFound        = "draft_not_found"
+	codeDraftTooLong         = "draft_too_long"
+	codeAlreadyReported      = "already_reported"
+	codeBatchTooLarge        = "batch_too_large"
+	codeInvalidResetToken    = "invalid_reset_token"
+	codeVersionConflict      = "version_conflict"
+	codeRateLimited          = "rate_limited"
+	codeLoginLocked          = "login_locked"
+	codeWebhookExpired       = "webhook_expired"
+	codeTimeout              = "timeout"
+	codeServerBusy           = "server_busy"
+	codeInternalError        = "internal_error"
+)
+
+// chirpValidationError is a chirp rule violation whose message is shown to clients.
+type chirpValidationError struct {
+	code    string
+	message string
+}
+
+func (e *chirpValidationError) Error() string {
+	return e.message
+}
+
+// Is matches any chirpValidationError with the same code, so callers can
+// compare against the sentinels even when the message carries details.
+func (e *chirpValidationError) Is(target error) bool {
+	t, ok := target.(*chirpValidationError)
+	return ok && t.code == e.code
+}
+
+// chirpErrorCode returns the error code for a validateChirp failure.
+func chirpErrorCode(err error) string {
+	var validationErr *chirpValidationError
+	if errors.As(err, &validationErr) {
+		return validationErr.code
+	}
+	return codeInvalidPayload
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"chirpy/internal/database"
+	"database/sql"
+	"encoding/csv"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// csvExportBatchSize is how many chirps are read from the database per round trip.
+const csvExportBatchSize = 500
+
+// exportChirpsCSVHandler streams every chirp, oldest first, as a CSV attachment.
+// Chirps are read in keyset-paginated batches so memory stays flat however
+// many chirps there are.
+func (cfg *apiConfig) exportChirpsCSVHandler(w http.ResponseWriter, r *http.Request) {
+	err := cfg.authorizeAdmin(r.Header)
+	if err != nil {
+		respondWithErrorCode(w, http.StatusUnauthorized, codeInvalidAPIKey, "Invalid or missing admin API key")
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", `attachment; filename="chirps.csv"`)
+	w.WriteHeader(http.StatusOK)
+
+	// csv.Writer quotes bodies containing commas, quotes, or newlines
+	writer := csv.NewWriter(w)
+	writer.Write([]string{"id", "created_at", "updated_at", "user_id", "body"})
+
+	// The export is a full copy, so it keeps chirps by deactivated users and
+	// private chirps, and excludes no one
+	params := database.GetChirpsPageParams{
+		IncludeInactive: true,
+		ExcludedUserIds: []uuid.UUID{},
+		IncludePrivate:  true,
+		PageLimit:       sql.NullInt32{Int32: csvExportBatchSize, Valid: true},
+	}
+	for {
+		dbChirps, err := cfg.DB.GetChirpsPage(r.Context(), params)
+		if err != nil {
+			// The 200 is already on the wire, so break the connection instead
+			// of ending cleanly; clients then see the export as failed rather
+			// than complete
+			slog.Error("Failed to export chirps", "request_id", requestIDFromContext(r.Context()), "error", err)
+			panic(http.ErrAbortHandler)
+		}
+
+		for _, dbChirp := range dbChirps {
+			writer.Write([]string{
+				dbChirp.ID.String(),
+				dbChirp.CreatedAt.Format(time.RFC3339Nano),
+				dbChirp.UpdatedAt.Format(time.RFC3339Nano),
+				dbChirp.UserID.String(),
+				dbChirp.Body,
+			})
+		}
+		writer.Flush()
+
+		if len(dbChirps) < csvExportBatchSize {
+			break
+		}
+
+		last := dbChirps[len(dbChirps)-1]
+		params.CursorCreatedAt = sql.NullTime{Time: last.CreatedAt, Valid: true}
+		params.CursorID = uuid.NullUUID{UUID: last.ID, Valid: true}
+	}
+
+	writer.Flush()
+	if err := writer.Error(); err != nil {
+		slog.Warn("Failed to write chirp export", "request_id", requestIDFromContext(r.Context()), "error", err)
+	}
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"slices"
+	"strings"
+)
+
+// chirpFieldNames are the top-level JSON keys of Chirp, read from its struct
+// tags so new fields become selectable without being listed here.
+var chirpFieldNames = jsonFieldNames(reflect.TypeFor[Chirp]())
+
+// jsonFieldNames returns the JSON key of each exported field of struct type t.
+func jsonFieldNames(t reflect.Type) []string {
+	names := []string{}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		names = append(names, name)
+	}
+	return names
+}
+
+// chirpFields is the set of keys requested with ?fields=, sorted. A nil
+// chirpFields means the client didn't ask, and every field is returned.
+type chirpFields []string
+
+// parseChirpFields reads the optional comma-separated 'fields' query parameter.
+func parseChirpFields(r *http.Request) (chirpFields, error) {
+	fieldsStr := r.URL.Query().Get("fields")
+	if fieldsStr == "" {
+		return nil, nil
+	}
+
+	fields := chirpFields{}
+	for _, name := range strings.Split(fieldsStr, ",") {
+		name = strings.TrimSpace(name)
+		if !slices.Contains(chirpFieldNames, name) {
+			return nil, fmt.Errorf("unknown field %q", name)
+		}
+		if !slices.Contains(fields, name) {
+			fields = append(fields, name)
+		}
+	}
+	slices.Sort(fields)
+	return fields, nil
+}
+
+// selectOne returns chirp with only the requested keys, built by marshaling
+// the whole chirp so omitempty and custom encodings still apply.
+func (f chirpFields) selectOne(chirp Chirp) (any, error) {
+	if f == nil {
+		return chirp, nil
+	}
+
+	dat, err := json.Marshal(chirp)
+	if err != nil {
+		return nil, err
+	}
+	all := map[string]json.RawMessage{}
+	err = json.Unmarshal(dat, &all)
+	if err != nil {
+		return nil, err
+	}
+
+	selected := map[string]json.RawMessage{}
+	for _, name := range f {
+		if value, ok := all[name]; ok {
+			selected[name] = value
+		}
+	}
+	return selected, nil
+}
+
+// selectAll applies selectOne to each chirp, returning chirps unchanged when
+// no fields were requested.
+func (f chirpFields) selectAll(chirps []Chirp) (any, error) {
+	if f == nil {
+		return chirps, nil
+	}
+
+	selected := []any{}
+	for _, chirp := range chirps {
+		s, err := f.selectOne(chirp)
+		if err != nil {
+			return nil, err
+		}
+		selected = append(selected, s)
+	}
+	return selected, nil
+}
+
+// etag derives a distinct ETag for this field selection from the full
+// chirp's ETag, so caches never serve one selection for another.
+func (f chirpFields) etag(fullETag string) string {
+	if f == nil {
+		return fullETag
+	}
+
+	h := sha256.New()
+	fmt.Fprintf(h, "%s|%s", fullETag, strings.Join(f, ","))
+	return `"` + hex.EncodeToString(h.Sum(nil)[:16]) + `"`
+}
@@ -11,3 +11,7 @@ require (
 )
 
 require github.com/golang-jwt/jwt/v5 v5.3.0
+
+require github.com/gorilla/websocket v1.5.3
+
+require golang.org/x/text v0.28.0
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"chirpy/internal/database"
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+const (
+	defaultTrendWindowHours = 24
+	maxTrendWindowHours     = 24 * 30
+	defaultTrendLimit       = 10
+	maxTrendLimit           = 100
+)
+
+// Trend represents a hashtag and how many recent chirps used it.
+type Trend struct {
+	Tag   string `json:"tag"`
+	Count int64  `json:"count"`
+}
+
+// extractHashtags returns the distinct, lowercased #tags in a chirp body.
+// A tag runs from the '#' up to the first character that isn't a letter, digit,
+// or underscore, so trailing punctuation like "#go!" is dropped.
+func extractHashtags(body string) []string {
+	tags := []string{}
+	seen := map[string]bool{}
+
+	for _, word := range strings.Fields(body) {
+		if !strings.HasPrefix(word, "#") {
+			continue
+		}
+
+		tag := strings.TrimPrefix(word, "#")
+		end := strings.IndexFunc(tag, func(r rune) bool {
+			return !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '_'
+		})
+		if end != -1 {
+			tag = tag[:end]
+		}
+
+		tag = strings.ToLower(tag)
+		if tag == "" || seen[tag] {
+			continue
+		}
+		seen[tag] = true
+		tags = append(tags, tag)
+	}
+
+	return tags
+}
+
+// createChirpWithHashtags inserts a chirp and indexes its hashtags using q,
+// which should be transaction-scoped so the two writes land together.
+func createChirpWithHashtags(ctx context.Context, q *database.Queries, params database.CreateChirpParams) (database.Chirp, error) {
+	dbChirp, err := q.CreateChirp(ctx, params)
+	if err != nil {
+		return database.Chirp{}, err
+	}
+
+	for _, tag := range extractHashtags(dbChirp.Body) {
+		err = q.CreateChirpHashtag(ctx, database.CreateChirpHashtagParams{
+			ChirpID: dbChirp.ID,
+			Tag:     tag,
+		})
+		if err != nil {
+			return database.Chirp{}, err
+		}
+	}
+
+	return dbChirp, nil
+}
+
+// getChirpsByHashtagHandler retrieves all chirps tagged with the given hashtag.
+func (cfg *apiConfig) getChirpsByHashtagHandler(w http.ResponseWriter, r *http.Request) {
+	// Tags are stored lowercased and without the leading '#'
+	tag := strings.ToLower(strings.TrimPrefix(r.PathValue("tag"), "#"))
+	if tag == "" {
+		respondWithErrorCode(w, http.StatusBadRequest, codeInvalidParameter, "Invalid hashtag")
+		return
+	}
+
+	escapeHTML, err := parseEscapeHTML(r)
+	if err != nil {
+		respondWithErrorCode(w, http.StatusBadRequest, codeInvalidParameter, "Invalid escape parameter, only 'html' is supported")
+		return
+	}
+
+	viewer, ok := cfg.requestViewer(w, r)
+	if !ok {
+		return
+	}
+
+	dbChirps, err := retryRead(r.Context(), func() ([]database.Chirp, error) {
+		return cfg.DB.GetChirpsByHashtag(r.Context(), database.GetChirpsByHashtagParams{Tag: tag, ViewerID: viewer.id})
+	})
+	if err != nil {
+		respondWithErrorCode(w, http.StatusInternalServerError, codeInternalError, "Failed to retrieve chirps")
+		return
+	}
+
+	chirps := []Chirp{}
+	for _, dbChirp := range dbChirps {
+		chirps = append(chirps, databaseChirpToChirp(dbChirp))
+	}
+
+	chirps = visibleChirps(chirps, viewer)
+
+	err = cfg.embedQuotedChirps(r.Context(), chirps, viewer)
+	if err != nil {
+		respondWithErrorCode(w, http.StatusInternalServerError, codeInternalError, "Failed to retrieve quoted chirps")
+		return
+	}
+
+	cfg.flagProfanity(chirps)
+	if escapeHTML {
+		escapeChirpBodies(chirps)
+	}
+
+	respondWithJSON(w, http.StatusOK, chirps)
+}
+
+// getTrendsHandler returns the most used hashtags over a recent window.
+func (cfg *apiConfig) getTrendsHandler(w http.ResponseWriter, r *http.Request) {
+	windowHours := defaultTrendWindowHours
+	if windowStr := r.URL.Query().Get("window"); windowStr != "" {
+		n, err := strconv.Atoi(windowStr)
+		if err != nil || n < 1 || n > maxTrendWindowHours {
+			respondWithErrorCode(w, http.StatusBadRequest, codeInvalidParameter, "window must be between 1 and "+strconv.Itoa(maxTrendWindowHours)+" hours")
+			return
+		}
+		windowHours = n
+	}
+
+	limit := defaultTrendLimit
+	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
+		n, err := strconv.Atoi(limitStr)
+		if err != nil || n < 1 || n > maxTrendLimit {
+			respondWithErrorCode(w, http.StatusBadRequest, codeInvalidParameter, "limit must be between 1 and "+strconv.Itoa(maxTrendLimit))
+			return
+		}
+		limit = n
+	}
+
+	since := time.Now().UTC().Add(-time.Duration(windowHours) * time.Hour)
+	rows, err := retryRead(r.Context(), func() ([]database.GetTrendingHashtagsRow, error) {
+		return cfg.DB.GetTrendingHashtags(r.Context(), database.GetTrendingHashtagsParams{
+			CreatedAt: since,
+			Limit:     int32(limit),
+		})
+	})
+	if err != nil {
+		respondWithErrorCode(w, http.StatusInternalServerError, codeInternalError, "Failed to retrieve trends")
+		return
+	}
+
+	trends := []Trend{}
+	for _, row := range rows {
+		trends = append(trends, Trend{
+			Tag:   row.Tag,
+			Count: row.ChirpCount,
+		})
+	}
+
+	respondWithJSON(w, http.StatusOK, trends)
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"chirpy/internal/database"
+	"context"
+	"errors"
+	"log/slog"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	// idempotencyKeyHeader lets clients safely retry chirp creation.
+	idempotencyKeyHeader = "Idempotency-Key"
+	// idempotencyKeyTTL is how long a replayed key returns the original chirp.
+	idempotencyKeyTTL = 24 * time.Hour
+	// maxIdempotencyKeyLength bounds the stored key size.
+	maxIdempotencyKeyLength = 255
+)
+
+// errIdempotencyKeyTaken means a concurrent request already claimed the key.
+var errIdempotencyKeyTaken = errors.New("idempotency key already used")
+
+// getIdempotentChirp returns the chirp a user previously created with key, if still live.
+func (cfg *apiConfig) getIdempotentChirp(ctx context.Context, userID uuid.UUID, key string) (database.Chirp, error) {
+	return cfg.DB.GetIdempotentChirp(ctx, database.GetIdempotentChirpParams{
+		UserID:    userID,
+		Key:       key,
+		CreatedAt: time.Now().UTC().Add(-idempotencyKeyTTL),
+	})
+}
+
+// claimIdempotencyKey records key against a newly created chirp using q, which
+// should be the chirp's transaction so a lost race rolls the chirp back.
+func claimIdempotencyKey(ctx context.Context, q *database.Queries, userID uuid.UUID, key string, chirpID uuid.UUID) error {
+	now := time.Now().UTC()
+
+	// An expired row for the same key is overwritten; a live one is left alone
+	claimed, err := q.CreateIdempotencyKey(ctx, database.CreateIdempotencyKeyParams{
+		UserID:        userID,
+		Key:           key,
+		ChirpID:       chirpID,
+		CreatedAt:     now,
+		ExpiredBefore: now.Add(-idempotencyKeyTTL),
+	})
+	if err != nil {
+		return err
+	}
+
+	if claimed == 0 {
+		return errIdempotencyKeyTaken
+	}
+
+	return nil
+}
+
+// cleanupIdempotencyKeys periodically deletes keys older than idempotencyKeyTTL.
+func (cfg *apiConfig) cleanupIdempotencyKeys(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		deleted, err := cfg.DB.DeleteExpiredIdempotencyKeys(context.Background(), time.Now().UTC().Add(-idempotencyKeyTTL))
+		if err != nil {
+			slog.Error("Failed to delete expired idempotency keys", "error", err)
+			continue
+		}
+		slog.Debug("Deleted expired idempotency keys", "deleted", deleted)
+	}
+}
@@ -38,6 +38,9 @@ func MakeRefreshToken() (string, error) {
 	return hex.EncodeToString(bytes), nil
 }
 
+// defaultIssuer is the iss claim used unless a KeyRing is configured otherwise.
+const defaultIssuer = "chirpy"
+
 var ErrNoAuthHeaderIncluded = errors.New("no authorization header included")
 
 // GetBearerToken extracts the bearer token string from the Authorization header.
@@ -57,16 +60,28 @@ func GetBearerToken(headers http.Header) (string, error) {
 
 // MakeJWT creates and signs a new JWT.
 func MakeJWT(userID uuid.UUID, tokenSecret string, expiresIn time.Duration) (string, error) {
+	return makeJWT(userID, "", tokenSecret, defaultIssuer, "", expiresIn)
+}
+
+// makeJWT signs a JWT, naming the signing key in the kid header when kid is set.
+// An empty audience leaves the aud claim out.
+func makeJWT(userID uuid.UUID, kid, tokenSecret, issuer, audience string, expiresIn time.Duration) (string, error) {
 	// Define the claims for the token
 	claims := jwt.RegisteredClaims{
-		Issuer:    "chirpy",
+		Issuer:    issuer,
 		IssuedAt:  jwt.NewNumericDate(time.Now().UTC()),
 		ExpiresAt: jwt.NewNumericDate(time.Now().UTC().Add(expiresIn)),
 		Subject:   userID.String(),
 	}
+	if audience != "" {
+		claims.Audience = jwt.ClaimStrings{audience}
+	}
 
 	// Create a new token with the claims and signing method
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	if kid != "" {
+		token.Header["kid"] = kid
+	}
 
 	// Sign the token with the secret key
 	signedToken, err := token.SignedString([]byte(tokenSecret))
@@ -79,11 +94,16 @@ func MakeJWT(userID uuid.UUID, tokenSecret string, expiresIn time.Duration) (str
 
 // ValidateJWT validates a JWT and extracts the user ID.
 func ValidateJWT(tokenString, tokenSecret string) (uuid.UUID, error) {
-	claims := &jwt.RegisteredClaims{}
-
-	token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
+	return validateJWT(tokenString, func(token *jwt.Token) (interface{}, error) {
 		return []byte(tokenSecret), nil
 	})
+}
+
+// validateJWT validates a JWT using keyFunc to pick the secret and extracts the user ID.
+func validateJWT(tokenString string, keyFunc jwt.Keyfunc, opts ...jwt.ParserOption) (uuid.UUID, error) {
+	claims := &jwt.RegisteredClaims{}
+
+	token, err := jwt.ParseWithClaims(tokenString, claims, keyFunc, opts...)
 
 	if err != nil {
 		return uuid.Nil, err
@@ -102,6 +122,21 @@ func ValidateJWT(tokenString, tokenSecret string) (uuid.UUID, error) {
 	return userID, nil
 }
 
+// DecodeJWT reads a JWT's claims and kid header without checking its
+// signature, expiry, issuer, or audience. It is for diagnosing tokens only;
+// never trust what it returns.
+func DecodeJWT(tokenString string) (*jwt.RegisteredClaims, string, error) {
+	claims := &jwt.RegisteredClaims{}
+
+	token, _, err := jwt.NewParser().ParseUnverified(tokenString, claims)
+	if err != nil {
+		return nil, "", err
+	}
+
+	kid, _ := token.Header["kid"].(string)
+	return claims, kid, nil
+}
+
 // HashPassword hashes a password using bcrypt.
 func HashPassword(password string) (string, error) {
 	bytes, err := bcrypt.GenerateFromPassword([]byte(password), 14)
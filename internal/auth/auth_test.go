@@ -1,6 +1,8 @@
 package auth
 
 import (
+	"errors"
+	"strings"
 	"testing"
 	"time"
 
@@ -45,6 +47,32 @@ func TestValidateJWTExpired(t *testing.T) {
 	}
 }
 
+func TestDecodeJWTIgnoresExpiry(t *testing.T) {
+	userID := uuid.New()
+	keys, err := NewKeyRing("k1", map[string]string{"k1": "test-secret"})
+	if err != nil {
+		t.Fatalf("NewKeyRing failed: %v", err)
+	}
+
+	tokenString, err := keys.MakeJWT(userID, -time.Minute)
+	if err != nil {
+		t.Fatalf("MakeJWT failed: %v", err)
+	}
+
+	claims, kid, err := DecodeJWT(tokenString)
+	if err != nil {
+		t.Fatalf("DecodeJWT failed on an expired token: %v", err)
+	}
+	if claims.Subject != userID.String() || claims.Issuer != defaultIssuer || kid != "k1" {
+		t.Errorf("DecodeJWT = %+v, kid %q; want subject %s, issuer %s, kid k1", claims, kid, userID, defaultIssuer)
+	}
+
+	_, _, err = DecodeJWT("not-a-jwt")
+	if err == nil {
+		t.Error("Expected an error for a malformed token, but got none")
+	}
+}
+
 func TestValidateJWTWrongSecret(t *testing.T) {
 	// Wrong secret
 	userID := uuid.New()
@@ -61,3 +89,148 @@ func TestValidateJWTWrongSecret(t *testing.T) {
 		t.Fatalf("Expected an error for wrong secret, but got none")
 	}
 }
+
+func TestKeyRingRotation(t *testing.T) {
+	userID := uuid.New()
+
+	// Before rotation, "old" is the current key
+	oldRing, err := ParseKeyRing("old:old-secret")
+	if err != nil {
+		t.Fatalf("ParseKeyRing failed: %v", err)
+	}
+	oldToken, err := oldRing.MakeJWT(userID, time.Hour)
+	if err != nil {
+		t.Fatalf("MakeJWT failed: %v", err)
+	}
+
+	// After rotation, "new" signs while "old" still verifies
+	ring, err := ParseKeyRing("new:new-secret, old:old-secret")
+	if err != nil {
+		t.Fatalf("ParseKeyRing failed: %v", err)
+	}
+	newToken, err := ring.MakeJWT(userID, time.Hour)
+	if err != nil {
+		t.Fatalf("MakeJWT failed: %v", err)
+	}
+
+	for name, tokenString := range map[string]string{"current key": newToken, "previous key": oldToken} {
+		validatedID, err := ring.ValidateJWT(tokenString)
+		if err != nil {
+			t.Errorf("ValidateJWT with %s failed: %v", name, err)
+			continue
+		}
+		if validatedID != userID {
+			t.Errorf("ValidateJWT with %s = %s, want %s", name, validatedID, userID)
+		}
+	}
+
+	// New tokens must be signed with the new secret, not just labelled with its kid
+	if _, err := ValidateJWT(newToken, "new-secret"); err != nil {
+		t.Errorf("token from rotated ring was not signed with the current secret: %v", err)
+	}
+}
+
+func TestKeyRingRejectsRetiredKey(t *testing.T) {
+	userID := uuid.New()
+
+	oldRing, err := ParseKeyRing("old:old-secret")
+	if err != nil {
+		t.Fatalf("ParseKeyRing failed: %v", err)
+	}
+	oldToken, err := oldRing.MakeJWT(userID, time.Hour)
+	if err != nil {
+		t.Fatalf("MakeJWT failed: %v", err)
+	}
+
+	// Once the previous key is dropped, its tokens no longer validate
+	ring, err := ParseKeyRing("new:new-secret")
+	if err != nil {
+		t.Fatalf("ParseKeyRing failed: %v", err)
+	}
+	if _, err := ring.ValidateJWT(oldToken); !errors.Is(err, ErrUnknownKeyID) {
+		t.Errorf("ValidateJWT with retired key error = %v, want %v", err, ErrUnknownKeyID)
+	}
+}
+
+func TestKeyRingValidatesTokensWithoutKeyID(t *testing.T) {
+	// Tokens issued before key IDs were introduced are checked against the current key
+	userID := uuid.New()
+	tokenString, err := MakeJWT(userID, "secret", time.Hour)
+	if err != nil {
+		t.Fatalf("MakeJWT failed: %v", err)
+	}
+
+	ring, err := ParseKeyRing("current:secret")
+	if err != nil {
+		t.Fatalf("ParseKeyRing failed: %v", err)
+	}
+	if _, err := ring.ValidateJWT(tokenString); err != nil {
+		t.Errorf("ValidateJWT without kid failed: %v", err)
+	}
+}
+
+func TestParseKeyRingInvalid(t *testing.T) {
+	tests := []string{"", "nosecret", "kid:", ":secret", "a:one,a:two"}
+
+	for _, input := range tests {
+		if _, err := ParseKeyRing(input); err == nil {
+			t.Errorf("ParseKeyRing(%q) expected an error, got none", input)
+		}
+	}
+}
+
+func TestKeyRingRejectsOtherAudience(t *testing.T) {
+	userID := uuid.New()
+
+	staging, err := ParseKeyRing("shared:shared-secret")
+	if err != nil {
+		t.Fatalf("ParseKeyRing failed: %v", err)
+	}
+	staging.SetClaims("chirpy", "chirpy-staging")
+
+	prod, err := ParseKeyRing("shared:shared-secret")
+	if err != nil {
+		t.Fatalf("ParseKeyRing failed: %v", err)
+	}
+	prod.SetClaims("chirpy", "chirpy-prod")
+
+	tokenString, err := staging.MakeJWT(userID, time.Hour)
+	if err != nil {
+		t.Fatalf("MakeJWT failed: %v", err)
+	}
+
+	if _, err := staging.ValidateJWT(tokenString); err != nil {
+		t.Errorf("ValidateJWT for matching audience failed: %v", err)
+	}
+	if _, err := prod.ValidateJWT(tokenString); err == nil {
+		t.Errorf("Expected an error for a token minted for another audience, but got none")
+	}
+
+	prod.SetClaims("other-issuer", "chirpy-staging")
+	if _, err := prod.ValidateJWT(tokenString); err == nil {
+		t.Errorf("Expected an error for a token from another issuer, but got none")
+	}
+}
+
+func TestWebhookSignature(t *testing.T) {
+	body := []byte(`{"id":"123","body":"hello"}`)
+	secret := "webhook-secret"
+
+	signature := SignWebhookPayload(body, secret)
+	if !strings.HasPrefix(signature, "sha256=") {
+		t.Fatalf("SignWebhookPayload = %q, want a sha256= prefix", signature)
+	}
+
+	if !VerifyWebhookSignature(body, secret, signature) {
+		t.Errorf("VerifyWebhookSignature rejected a valid signature")
+	}
+	if VerifyWebhookSignature([]byte(`{"id":"123","body":"tampered"}`), secret, signature) {
+		t.Errorf("VerifyWebhookSignature accepted a signature for a different body")
+	}
+	if VerifyWebhookSignature(body, "wrong-secret", signature) {
+		t.Errorf("VerifyWebhookSignature accepted a signature made with a different secret")
+	}
+	if VerifyWebhookSignature(body, secret, strings.TrimPrefix(signature, "sha256=")) {
+		t.Errorf("VerifyWebhookSignature accepted a signature without its algorithm prefix")
+	}
+}
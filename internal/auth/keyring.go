@@ -0,0 +1,98 @@
+package auth
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+var ErrUnknownKeyID = errors.New("unknown JWT key ID")
+
+// KeyRing holds the JWT signing secrets currently in use, indexed by key ID.
+// New tokens are signed with the current key and carry its ID in the "kid"
+// header; any key in the ring can verify tokens it signed, so the previous
+// secret keeps working until the tokens signed with it expire.
+type KeyRing struct {
+	currentKID string
+	secrets    map[string]string
+	issuer     string
+	audience   string
+}
+
+// NewKeyRing returns a key ring that signs with secrets[currentKID].
+// An empty currentKID signs tokens without a kid header, as MakeJWT does.
+func NewKeyRing(currentKID string, secrets map[string]string) (*KeyRing, error) {
+	if secrets[currentKID] == "" {
+		return nil, errors.New("current JWT key is missing or empty")
+	}
+
+	return &KeyRing{
+		currentKID: currentKID,
+		secrets:    secrets,
+		issuer:     defaultIssuer,
+	}, nil
+}
+
+// SetClaims sets the issuer and audience that new tokens carry and that
+// ValidateJWT requires, so tokens minted for another service or environment
+// sharing the secret are rejected. An empty audience is neither set nor checked.
+func (k *KeyRing) SetClaims(issuer, audience string) {
+	k.issuer = issuer
+	k.audience = audience
+}
+
+// ParseKeyRing parses comma-separated kid:secret pairs such as
+// "2024-06:newsecret,2024-01:oldsecret". The first pair is the current key.
+func ParseKeyRing(s string) (*KeyRing, error) {
+	secrets := map[string]string{}
+	currentKID := ""
+
+	for i, pair := range strings.Split(s, ",") {
+		kid, secret, found := strings.Cut(strings.TrimSpace(pair), ":")
+		if !found || kid == "" || secret == "" {
+			return nil, errors.New("JWT keys must be kid:secret pairs")
+		}
+		if _, dup := secrets[kid]; dup {
+			return nil, errors.New("duplicate JWT key ID " + kid)
+		}
+
+		secrets[kid] = secret
+		if i == 0 {
+			currentKID = kid
+		}
+	}
+
+	return NewKeyRing(currentKID, secrets)
+}
+
+// MakeJWT creates a new JWT signed with the current key.
+func (k *KeyRing) MakeJWT(userID uuid.UUID, expiresIn time.Duration) (string, error) {
+	return makeJWT(userID, k.currentKID, k.secrets[k.currentKID], k.issuer, k.audience, expiresIn)
+}
+
+// ValidateJWT validates a JWT against the key named by its kid header, checks
+// its issuer and audience, and extracts the user ID. Tokens without a kid are
+// checked against the current key.
+func (k *KeyRing) ValidateJWT(tokenString string) (uuid.UUID, error) {
+	opts := []jwt.ParserOption{jwt.WithIssuer(k.issuer)}
+	if k.audience != "" {
+		opts = append(opts, jwt.WithAudience(k.audience))
+	}
+
+	return validateJWT(tokenString, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		if kid == "" {
+			kid = k.currentKID
+		}
+
+		secret, ok := k.secrets[kid]
+		if !ok {
+			return nil, ErrUnknownKeyID
+		}
+
+		return []byte(secret), nil
+	}, opts...)
+}
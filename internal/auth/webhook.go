@@ -0,0 +1,32 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+)
+
+// Webhook signatures let a receiver check that a request body came from a
+// holder of the shared secret and wasn't altered in transit. The signature is
+// the lowercase hex HMAC-SHA256 of the exact request body bytes, keyed with the
+// secret and prefixed with the algorithm, e.g. "sha256=5d41402abc4b2a76...".
+const webhookSignaturePrefix = "sha256="
+
+// SignWebhookPayload returns the signature for body under secret.
+func SignWebhookPayload(body []byte, secret string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return webhookSignaturePrefix + hex.EncodeToString(mac.Sum(nil))
+}
+
+// VerifyWebhookSignature reports whether signature is valid for body under secret.
+// The comparison is constant-time so it can't be used to guess signatures.
+func VerifyWebhookSignature(body []byte, secret, signature string) bool {
+	if !strings.HasPrefix(signature, webhookSignaturePrefix) {
+		return false
+	}
+
+	expected := SignWebhookPayload(body, secret)
+	return hmac.Equal([]byte(expected), []byte(signature))
+}
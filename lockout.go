@@ -0,0 +1,149 @@
+package main
+
+import (
+	"chirpy/internal/auth"
+	"chirpy/internal/database"
+	"context"
+	"database/sql"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// loginFailureTTL is how long an email's failure count is kept after its
+// last failed login, once any lock has ended.
+const loginFailureTTL = 24 * time.Hour
+
+// checkLoginLockout reports whether logins for email are locked and, if so,
+// for how much longer.
+//
+// Lockouts are per email, so anyone who knows an address can keep its owner
+// locked out by failing on purpose. The cooldown bounds how long that lasts;
+// it's the price of stopping guessing against a single account.
+func (cfg *apiConfig) checkLoginLockout(ctx context.Context, email string) (locked bool, retryAfter time.Duration, err error) {
+	// LOGIN_LOCKOUT_THRESHOLD=0, or an unconfigured apiConfig, disables lockout
+	if cfg.LockoutThreshold == 0 {
+		return false, 0, nil
+	}
+
+	failure, err := cfg.DB.GetLoginFailure(ctx, email)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return false, 0, nil
+		}
+		return false, 0, err
+	}
+
+	if !failure.LockedUntil.Valid {
+		return false, 0, nil
+	}
+	retryAfter = time.Until(failure.LockedUntil.Time)
+	return retryAfter > 0, retryAfter, nil
+}
+
+// checkCredentials verifies an email and password for login and anything else
+// that accepts them, applying the lockout: locked emails are refused before
+// the password is checked, failures are counted, and a match clears the
+// count. It writes an error response and returns false on failure.
+func (cfg *apiConfig) checkCredentials(w http.ResponseWriter, r *http.Request, email, password string) (database.User, bool) {
+	locked, retryAfter, err := cfg.checkLoginLockout(r.Context(), email)
+	if err != nil {
+		respondWithErrorCode(w, http.StatusInternalServerError, codeInternalError, "Failed to check login lockout")
+		return database.User{}, false
+	}
+	if locked {
+		respondWithLoginLocked(w, retryAfter)
+		return database.User{}, false
+	}
+
+	dbUser, err := cfg.DB.GetUserByEmail(r.Context(), email)
+	if err != nil {
+		cfg.recordFailedLogin(r, email)
+		respondWithErrorCode(w, http.StatusUnauthorized, codeInvalidCredentials, "Incorrect email or password")
+		return database.User{}, false
+	}
+
+	// Use bcrypt to check the password hash
+	err = auth.CheckPasswordHash(password, dbUser.HashedPassword)
+	if err != nil {
+		cfg.recordFailedLogin(r, email)
+		respondWithErrorCode(w, http.StatusUnauthorized, codeInvalidCredentials, "Incorrect email or password")
+		return database.User{}, false
+	}
+
+	cfg.clearLoginFailures(r, email)
+	return dbUser, true
+}
+
+// recordFailedLogin counts a failed login for email and locks it for the
+// cooldown once LockoutThreshold failures in a row are reached. Errors
+// are logged; the caller still answers with the usual 401.
+func (cfg *apiConfig) recordFailedLogin(r *http.Request, email string) {
+	if cfg.LockoutThreshold == 0 {
+		return
+	}
+
+	now := time.Now().UTC()
+	failure, err := cfg.DB.RecordFailedLogin(r.Context(), database.RecordFailedLoginParams{
+		Email:     email,
+		UpdatedAt: now,
+	})
+	if err != nil {
+		slog.Error("Failed to record failed login", "request_id", requestIDFromContext(r.Context()), "error", err)
+		return
+	}
+
+	if int(failure.FailedAttempts) < cfg.LockoutThreshold {
+		return
+	}
+
+	err = cfg.DB.LockLogin(r.Context(), database.LockLoginParams{
+		Email:       email,
+		LockedUntil: sql.NullTime{Time: now.Add(cfg.LockoutCooldown), Valid: true},
+		UpdatedAt:   now,
+	})
+	if err != nil {
+		slog.Error("Failed to lock login", "request_id", requestIDFromContext(r.Context()), "error", err)
+		return
+	}
+
+	slog.Warn("Locked login after repeated failures",
+		"request_id", requestIDFromContext(r.Context()),
+		"failed_attempts", failure.FailedAttempts,
+		"cooldown", cfg.LockoutCooldown.String(),
+		"remote_addr", r.RemoteAddr,
+	)
+}
+
+// clearLoginFailures resets the failure count for email after a successful login.
+func (cfg *apiConfig) clearLoginFailures(r *http.Request, email string) {
+	if cfg.LockoutThreshold == 0 {
+		return
+	}
+
+	err := cfg.DB.ClearLoginFailures(r.Context(), email)
+	if err != nil {
+		slog.Warn("Failed to clear failed logins", "request_id", requestIDFromContext(r.Context()), "error", err)
+	}
+}
+
+// respondWithLoginLocked writes a 423 with a Retry-After hint for when the lock ends.
+func respondWithLoginLocked(w http.ResponseWriter, retryAfter time.Duration) {
+	setRetryAfter(w, retryAfter)
+	respondWithErrorCode(w, http.StatusLocked, codeLoginLocked, "Too many failed login attempts, try again later")
+}
+
+// cleanupLoginFailures periodically deletes failure counts older than loginFailureTTL.
+func (cfg *apiConfig) cleanupLoginFailures(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		deleted, err := cfg.DB.DeleteStaleLoginFailures(context.Background(), time.Now().UTC().Add(-loginFailureTTL))
+		if err != nil {
+			slog.Error("Failed to delete stale login failures", "error", err)
+			continue
+		}
+		slog.Debug("Deleted stale login failures", "deleted", deleted)
+	}
+}
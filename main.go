@@ -3,17 +3,26 @@ package main
 import (
 	"chirpy/internal/auth"
 	"chirpy/internal/database"
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
 	"database/sql"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"html"
 	"log"
+	"log/slog"
+	"mime"
 	"net/http"
+	"net/http/pprof"
 	"os"
-	"slices"
 	"strconv"
 	"strings"
 	"sync/atomic"
 	"time"
+	"unicode/utf8"
 
 	"github.com/google/uuid"
 	"github.com/joho/godotenv"
@@ -24,11 +33,49 @@ import (
 type apiConfig struct {
 	fileserverHits atomic.Int32
 	DB             *database.Queries
+	Conn           *sql.DB
 	Platform       string
-	JWTSecret      string
+	JWTKeys        *auth.KeyRing
 	PolkaKey       string
+	AdminKey       string
+	MaxTokenExpiry time.Duration
+	// Access token lifetime when the client doesn't ask for one
+	AccessTokenTTL time.Duration
+	ChirpBroker    *chirpBroker
+	// Receives new chirps when CHIRP_WEBHOOK_URL is set; nil otherwise
+	ChirpWebhook *outboundWebhook
+	// Told about deleted accounts when BILLING_WEBHOOK_URL is set; nil otherwise
+	BillingWebhook *outboundWebhook
+	// Mails out reset tokens when PASSWORD_RESET_WEBHOOK_URL is set; nil otherwise
+	PasswordResetWebhook *outboundWebhook
+	// Order for GET /api/chirps when no sort is given: "asc", "desc", or "" for the query default
+	DefaultChirpOrder string
+	// Longest chirp accepted, in runes
+	MaxChirpLength int
+	// Maximum chirps per user per rolling hour; Chirpy Red users get their own limit
+	ChirpRateLimit    int
+	ChirpRateLimitRed int
+	// Whether profanity is censored on write or flagged on read
+	ProfanityMode profanityMode
+	// What censored words are replaced with
+	ProfanityReplacement string
+	// Whether profanity must be a whole word or may be part of a longer one
+	ProfanityMatch profanityMatch
+	// Cached totals for GET /api/stats
+	PlatformStats platformStatsCache
+	// Browser origins allowed to call the API, also checked on WebSocket handshakes
+	CORS *corsPolicy
+	// Recently rendered users, such as chirp authors; nil disables caching
+	UserCache *userCache
+	// Consecutive failed logins for one email before it is locked, and for
+	// how long; a zero threshold disables lockout
+	LockoutThreshold int
+	LockoutCooldown  time.Duration
 }
 
+// refreshTokenTTL is how long a refresh token stays valid if it isn't rotated first.
+const refreshTokenTTL = 60 * 24 * time.Hour
+
 // User represents the User data returned to the client.
 type User struct {
 	ID          uuid.UUID `json:"id"`
@@ -36,28 +83,107 @@ type User struct {
 	UpdatedAt   time.Time `json:"updated_at"`
 	Email       string    `json:"email"`
 	IsChirpyRed bool      `json:"is_chirpy_red"`
+	// IsVerified marks official accounts; unlike IsChirpyRed it is never
+	// changed by billing
+	IsVerified bool    `json:"is_verified"`
+	AvatarURL  *string `json:"avatar_url"`
+	Bio        string  `json:"bio"`
+	// PinnedChirpID is the chirp the user pinned to the top of their profile
+	PinnedChirpID *uuid.UUID `json:"pinned_chirp_id"`
+	// Active is false while the account is deactivated
+	Active bool `json:"active"`
+}
+
+// databaseUserToUser maps a database.User to the public User, dropping the password hash.
+func databaseUserToUser(dbUser database.User) User {
+	user := User{
+		ID:          dbUser.ID,
+		CreatedAt:   dbUser.CreatedAt,
+		UpdatedAt:   dbUser.UpdatedAt,
+		Email:       dbUser.Email,
+		IsChirpyRed: dbUser.IsChirpyRed,
+		IsVerified:  dbUser.IsVerified,
+		Bio:         dbUser.Bio,
+		Active:      dbUser.Active,
+	}
+	if dbUser.AvatarURL.Valid {
+		user.AvatarURL = &dbUser.AvatarURL.String
+	}
+	if dbUser.PinnedChirpID.Valid {
+		user.PinnedChirpID = &dbUser.PinnedChirpID.UUID
+	}
+	return user
+}
+
+// PublicUser is the profile anyone can see. Unlike User it leaves out the
+// email address and account state, so it's safe for unauthenticated reads.
+type PublicUser struct {
+	ID          uuid.UUID `json:"id"`
+	CreatedAt   time.Time `json:"created_at"`
+	AvatarURL   *string   `json:"avatar_url"`
+	Bio         string    `json:"bio"`
+	IsChirpyRed bool      `json:"is_chirpy_red"`
+	IsVerified  bool      `json:"is_verified"`
+}
+
+// databaseUserToPublicUser maps a database.User to its public profile.
+func databaseUserToPublicUser(dbUser database.User) PublicUser {
+	user := PublicUser{
+		ID:          dbUser.ID,
+		CreatedAt:   dbUser.CreatedAt,
+		Bio:         dbUser.Bio,
+		IsChirpyRed: dbUser.IsChirpyRed,
+		IsVerified:  dbUser.IsVerified,
+	}
+	if dbUser.AvatarURL.Valid {
+		user.AvatarURL = &dbUser.AvatarURL.String
+	}
+	return user
+}
+
+// etagKey identifies this version of the profile, for ETags of responses that
+// embed it. Public profiles carry no version, so every field is included.
+func (u PublicUser) etagKey() string {
+	avatarURL := ""
+	if u.AvatarURL != nil {
+		avatarURL = *u.AvatarURL
+	}
+	return fmt.Sprintf("%s|%q|%q|%t|%t", u.ID, avatarURL, u.Bio, u.IsChirpyRed, u.IsVerified)
 }
 
 // UserWithTokens represents the User data returned after successful login.
 type UserWithTokens struct {
-	ID           uuid.UUID `json:"id"`
-	CreatedAt    time.Time `json:"created_at"`
-	UpdatedAt    time.Time `json:"updated_at"`
-	Email        string    `json:"email"`
-	IsChirpyRed  bool      `json:"is_chirpy_red"`
-	Token        string    `json:"token"`
-	RefreshToken string    `json:"refresh_token"`
+	User
+	Token        string `json:"token"`
+	RefreshToken string `json:"refresh_token"`
+
+	// Expiry times let clients refresh proactively instead of waiting for a 401
+	TokenExpiresAt        time.Time `json:"token_expires_at"`
+	RefreshTokenExpiresAt time.Time `json:"refresh_token_expires_at"`
+	// ExpiresIn is the access token lifetime in seconds
+	ExpiresIn int64 `json:"expires_in"`
 }
 
 // createUserBody represents the expected JSON request body for a new user.
 type createUserBody struct {
-	Email    string `json:"email"`
-	Password string `json:"password"`
+	Email     string  `json:"email"`
+	Password  string  `json:"password"`
+	AvatarURL *string `json:"avatar_url"`
+	Bio       *string `json:"bio"`
 }
 
+// updateUserBody represents the expected JSON request body for a user update.
+// Omitting avatar_url or bio keeps the current value; an empty string clears it.
 type updateUserBody struct {
-	Email    string `json:"email"`
-	Password string `json:"password"`
+	Email     string  `json:"email"`
+	Password  string  `json:"password"`
+	AvatarURL *string `json:"avatar_url"`
+	Bio       *string `json:"bio"`
+}
+
+// revokeResponse reports the sessions left after a revocation.
+type revokeResponse struct {
+	RemainingSessions int64 `json:"remaining_sessions"`
 }
 
 // loginBody represents the expected JSON request body for a login request.
@@ -72,34 +198,126 @@ type Chirp struct {
 	ID        uuid.UUID `json:"id"`
 	CreatedAt time.Time `json:"created_at"`
 	UpdatedAt time.Time `json:"updated_at"`
-	Body      string    `json:"body"`
-	UserID    uuid.UUID `json:"user_id"`
+	// Body is stored and returned raw unless the client asks for ?escape=html
+	Body   string      `json:"body"`
+	UserID uuid.UUID   `json:"user_id"`
+	Author *PublicUser `json:"author,omitempty"`
+	// Visibility is "public", or "private" for chirps only their author can read
+	Visibility database.ChirpVisibility `json:"visibility"`
+	// Pinned is set on the author's pinned chirp when listing one user's chirps
+	Pinned bool `json:"pinned,omitempty"`
+	// ContainsProfanity is set in PROFANITY_MODE=flag, where bodies are stored uncensored
+	ContainsProfanity bool `json:"contains_profanity,omitempty"`
+	// QuotedChirpID is set on quote chirps. QuotedChirp summarizes the quoted
+	// chirp, or QuotedChirpUnavailable is set once it's gone or hidden.
+	QuotedChirpID          *uuid.UUID   `json:"quoted_chirp_id,omitempty"`
+	QuotedChirp            *QuotedChirp `json:"quoted_chirp,omitempty"`
+	QuotedChirpUnavailable bool         `json:"quoted_chirp_unavailable,omitempty"`
+}
+
+// databaseChirpToChirp maps a stored chirp to its JSON form. Authors, quotes,
+// and flags are filled in separately by the handlers that want them.
+func databaseChirpToChirp(dbChirp database.Chirp) Chirp {
+	chirp := Chirp{
+		ID:         dbChirp.ID,
+		CreatedAt:  dbChirp.CreatedAt,
+		UpdatedAt:  dbChirp.UpdatedAt,
+		Body:       dbChirp.Body,
+		UserID:     dbChirp.UserID,
+		Visibility: dbChirp.Visibility,
+	}
+	if dbChirp.QuotedChirpID.Valid {
+		chirp.QuotedChirpID = &dbChirp.QuotedChirpID.UUID
+	}
+	return chirp
 }
 
 // New `createChirpBody` struct for the incoming JSON
 type createChirpBody struct {
 	Body string `json:"body"`
+	// QuotedChirpID optionally makes this a quote of an existing chirp
+	QuotedChirpID *uuid.UUID `json:"quoted_chirp_id"`
+	// Visibility is "public" (the default) or "private"
+	Visibility string `json:"visibility"`
 }
 
 // errorResponse represents a generic JSON error response.
 type errorResponse struct {
 	Error string `json:"error"`
+	Code  string `json:"code,omitempty"`
+}
+
+// newID returns a time-ordered UUIDv7 for a new user or chirp, so new rows
+// land together at the end of the primary key index. IDs are still parsed as
+// any UUID version, so existing v4 IDs keep working.
+func newID() uuid.UUID {
+	// Like uuid.New, this only fails if the system random source does
+	return uuid.Must(uuid.NewV7())
+}
+
+// withTx runs fn against a transaction-scoped Queries, committing only if fn succeeds.
+func (cfg *apiConfig) withTx(ctx context.Context, fn func(q *database.Queries) error) error {
+	tx, err := cfg.Conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	// Rollback is a no-op once the transaction has been committed
+	defer tx.Rollback()
+
+	err = fn(cfg.DB.WithTx(tx))
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// defaultMaxChirpLength is the longest chirp accepted unless MAX_CHIRP_LENGTH says otherwise.
+const defaultMaxChirpLength = 140
+
+// errChirpTooLong matches, via errors.Is, the error validateChirp returns when
+// a chirp exceeds the length limit.
+var errChirpTooLong = &chirpValidationError{code: codeChirpTooLong, message: "Chirp is too long"}
+
+// errChirpEmpty is returned by validateChirp when a chirp has no visible content.
+var errChirpEmpty = &chirpValidationError{code: codeChirpEmpty, message: "Chirp cannot be empty"}
+
+// errInvalidVisibility is returned by parseChirpVisibility for an unknown visibility.
+var errInvalidVisibility = &chirpValidationError{code: codeInvalidVisibility, message: "Visibility must be 'public' or 'private'"}
+
+// parseChirpVisibility maps a requested visibility to its stored form. Chirps
+// are public unless the author asks otherwise.
+func parseChirpVisibility(visibility string) (database.ChirpVisibility, error) {
+	switch database.ChirpVisibility(visibility) {
+	case "", database.ChirpVisibilityPublic:
+		return database.ChirpVisibilityPublic, nil
+	case database.ChirpVisibilityPrivate:
+		return database.ChirpVisibilityPrivate, nil
+	}
+	return "", errInvalidVisibility
 }
 
-// sanitizeChirp replaces profane words in a given string.
-func sanitizeChirp(s string) string {
-	profaneWords := []string{"kerfuffle", "sharbert", "fornax"}
-	words := strings.Split(s, " ")
+// validateChirp checks a chirp body against the posting rules and returns it
+// trimmed, with profanity masked by replacement unless it is empty. Length is
+// counted in runes so emoji and accented characters count once each, as
+// readers see them.
+func validateChirp(body string, maxLength int, replacement string, match profanityMatch) (string, error) {
+	body = strings.TrimSpace(body)
+	if body == "" {
+		return "", errChirpEmpty
+	}
 
-	for i, word := range words {
-		cleanedWord := strings.ToLower(word)
-		isProfane := slices.Contains(profaneWords, cleanedWord)
-		if isProfane {
-			words[i] = "****"
+	if utf8.RuneCountInString(body) > maxLength {
+		return "", &chirpValidationError{
+			code:    codeChirpTooLong,
+			message: fmt.Sprintf("Chirp is too long, the limit is %d characters", maxLength),
 		}
 	}
 
-	return strings.Join(words, " ")
+	if replacement != "" {
+		body = sanitizeChirp(body, replacement, match)
+	}
+	return body, nil
 }
 
 // middlewareMetricsInc is a middleware that increments the fileserverHits counter.
@@ -119,47 +337,64 @@ func (cfg *apiConfig) metricsHandler(w http.ResponseWriter, r *http.Request) {
 
 	// Convert the integer to a string using strconv.Itoa()
 	w.Write([]byte("Hits: " + strconv.Itoa(int(hits))))
+
+	userCacheHits, userCacheMisses := cfg.UserCache.Stats()
+	w.Write([]byte("\nUser cache hits: " + strconv.FormatInt(userCacheHits, 10)))
+	w.Write([]byte("\nUser cache misses: " + strconv.FormatInt(userCacheMisses, 10)))
+}
+
+// resetResponse reports what resetHandler cleared so tests can assert on it.
+type resetResponse struct {
+	DeletedUsers int64 `json:"deleted_users"`
+	HitsReset    bool  `json:"hits_reset"`
 }
 
 // resetHandler resets the fileserverHits counter to zero and deletes all users if in dev.
 func (cfg *apiConfig) resetHandler(w http.ResponseWriter, r *http.Request) {
+	// Wiping everything stays dev-only; the admin key only unlocks read-only endpoints
 	if cfg.Platform != "dev" {
-		respondWithError(w, http.StatusForbidden, "Forbidden: This endpoint is only available in the 'dev' environment")
+		respondWithErrorCode(w, http.StatusForbidden, codeForbidden, "Forbidden: This endpoint is only available in the 'dev' environment")
 		return
 	}
 
 	// Delete all chirps and refresh tokens first to satisfy foreign key constraints
 	err := cfg.DB.DeleteChirps(r.Context())
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Failed to delete chirps")
+		respondWithErrorCode(w, http.StatusInternalServerError, codeInternalError, "Failed to delete chirps")
 		return
 	}
 
 	err = cfg.DB.DeleteRefreshTokens(r.Context())
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Failed to delete refresh tokens")
+		respondWithErrorCode(w, http.StatusInternalServerError, codeInternalError, "Failed to delete refresh tokens")
 		return
 	}
 
 	// Then, delete all users
-	err = cfg.DB.DeleteUsers(r.Context())
+	deletedUsers, err := cfg.DB.DeleteUsers(r.Context())
+	cfg.UserCache.Clear()
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Failed to delete users")
+		respondWithErrorCode(w, http.StatusInternalServerError, codeInternalError, "Failed to delete users")
 		return
 	}
 
 	// Reset fileserver hits
 	cfg.fileserverHits.Store(0)
 
-	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
-	w.WriteHeader(http.StatusOK)
-	w.Write([]byte("OK"))
+	respondWithJSON(w, http.StatusOK, resetResponse{
+		DeletedUsers: deletedUsers,
+		HitsReset:    true,
+	})
 }
 
 // adminMetricsHandler returns an HTML page with the hit count.
 func (cfg *apiConfig) adminMetricsHandler(w http.ResponseWriter, r *http.Request) {
 	hits := cfg.fileserverHits.Load()
 
+	// A failed count shouldn't take down the whole page
+	users := countOrUnavailable(cfg.DB.CountUsers(r.Context()))
+	chirps := countOrUnavailable(cfg.DB.CountChirps(r.Context()))
+
 	w.Header().Set("Content-Type", "text/html; charset=utf-8")
 	w.WriteHeader(http.StatusOK)
 
@@ -168,31 +403,60 @@ func (cfg *apiConfig) adminMetricsHandler(w http.ResponseWriter, r *http.Request
 	<body>
 	  <h1>Welcome, Chirpy Admin</h1>
 	  <p>Chirpy has been visited %d times!</p>
+	  <p>Users: %s</p>
+	  <p>Chirps: %s</p>
 	</body>
-</html>`, hits)
+</html>`, hits, users, chirps)
 	w.Write([]byte(html))
 }
 
+// countOrUnavailable formats a count for display, or "unavailable" if it couldn't be read.
+func countOrUnavailable(n int64, err error) string {
+	if err != nil {
+		slog.Error("Failed to count rows for admin metrics", "error", err)
+		return "unavailable"
+	}
+	return strconv.FormatInt(n, 10)
+}
+
 // createUserHandler creates a new user in the database.
 func (cfg *apiConfig) createUserHandler(w http.ResponseWriter, r *http.Request) {
+	if !requireJSONContentType(w, r) {
+		return
+	}
+
 	decoder := json.NewDecoder(r.Body)
 	var reqBody createUserBody
 
 	err := decoder.Decode(&reqBody)
 	if err != nil {
-		respondWithError(w, http.StatusBadRequest, "Invalid request payload")
+		respondWithErrorCode(w, http.StatusBadRequest, codeInvalidPayload, "Invalid request payload")
+		return
+	}
+
+	avatarURL, err := avatarURLParam(reqBody.AvatarURL)
+	if err != nil {
+		respondWithErrorCode(w, http.StatusUnprocessableEntity, codeInvalidAvatarURL, err.Error())
+		return
+	}
+	// An empty avatar on signup is the same as none at all
+	avatarURL.Valid = avatarURL.String != ""
+
+	bio, err := bioParam(reqBody.Bio, cfg.ProfanityReplacement, cfg.ProfanityMatch)
+	if err != nil {
+		respondWithErrorCode(w, http.StatusUnprocessableEntity, codeBioTooLong, err.Error())
 		return
 	}
 
 	// Hash the password before storing it
 	hashedPassword, err := auth.HashPassword(reqBody.Password)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Failed to hash password")
+		respondWithErrorCode(w, http.StatusInternalServerError, codeInternalError, "Failed to hash password")
 		return
 	}
 
 	now := time.Now().UTC()
-	id := uuid.New()
+	id := newID()
 
 	dbUser, err := cfg.DB.CreateUser(r.Context(), database.CreateUserParams{
 		ID:             id,
@@ -200,20 +464,17 @@ func (cfg *apiConfig) createUserHandler(w http.ResponseWriter, r *http.Request)
 		UpdatedAt:      now,
 		Email:          reqBody.Email,
 		HashedPassword: hashedPassword,
+		AvatarURL:      avatarURL,
+		Bio:            bio.String,
 	})
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Failed to create user")
+		respondWithErrorCode(w, http.StatusInternalServerError, codeInternalError, "Failed to create user")
 		return
 	}
 
-	user := User{
-		ID:          dbUser.ID,
-		CreatedAt:   dbUser.CreatedAt,
-		UpdatedAt:   dbUser.UpdatedAt,
-		Email:       dbUser.Email,
-		IsChirpyRed: dbUser.IsChirpyRed,
-	}
+	user := databaseUserToUser(dbUser)
 
+	w.Header().Set("ETag", userETag(dbUser.Version))
 	respondWithJSON(w, http.StatusCreated, user)
 }
 
@@ -221,172 +482,436 @@ func (cfg *apiConfig) updateUserHandler(w http.ResponseWriter, r *http.Request)
 	// 1. Authenticate the user with the JWT
 	tokenString, err := auth.GetBearerToken(r.Header)
 	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT")
+		respondWithErrorCode(w, http.StatusUnauthorized, codeMissingToken, "Couldn't find JWT")
 		return
 	}
 
-	userID, err := auth.ValidateJWT(tokenString, cfg.JWTSecret)
+	userID, err := cfg.JWTKeys.ValidateJWT(tokenString)
 	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Invalid JWT")
+		respondWithErrorCode(w, http.StatusUnauthorized, codeInvalidToken, "Invalid JWT")
 		return
 	}
 
 	// 2. Decode the request body with the new email and password
+	if !requireJSONContentType(w, r) {
+		return
+	}
+
 	decoder := json.NewDecoder(r.Body)
 	var reqBody updateUserBody
 	err = decoder.Decode(&reqBody)
 	if err != nil {
-		respondWithError(w, http.StatusBadRequest, "Invalid request payload")
+		respondWithErrorCode(w, http.StatusBadRequest, codeInvalidPayload, "Invalid request payload")
+		return
+	}
+
+	avatarURL, err := avatarURLParam(reqBody.AvatarURL)
+	if err != nil {
+		respondWithErrorCode(w, http.StatusUnprocessableEntity, codeInvalidAvatarURL, err.Error())
+		return
+	}
+
+	bio, err := bioParam(reqBody.Bio, cfg.ProfanityReplacement, cfg.ProfanityMatch)
+	if err != nil {
+		respondWithErrorCode(w, http.StatusUnprocessableEntity, codeBioTooLong, err.Error())
+		return
+	}
+
+	// Clients that send If-Match only update the version they last saw;
+	// without it the update is last-write-wins
+	expectedVersion, err := parseIfMatchVersion(r.Header.Get("If-Match"))
+	if err != nil {
+		respondWithErrorCode(w, http.StatusBadRequest, codeInvalidParameter, "Invalid If-Match header")
 		return
 	}
 
 	// 3. Hash the new password
 	hashedPassword, err := auth.HashPassword(reqBody.Password)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Failed to hash password")
+		respondWithErrorCode(w, http.StatusInternalServerError, codeInternalError, "Failed to hash password")
 		return
 	}
 
 	// 4. Update the user in the database
 	updatedUser, err := cfg.DB.UpdateUser(r.Context(), database.UpdateUserParams{
-		ID:             userID,
-		Email:          reqBody.Email,
-		HashedPassword: hashedPassword,
-		UpdatedAt:      time.Now().UTC(),
+		ID:              userID,
+		Email:           reqBody.Email,
+		HashedPassword:  hashedPassword,
+		UpdatedAt:       time.Now().UTC(),
+		AvatarURL:       avatarURL,
+		Bio:             bio,
+		ExpectedVersion: expectedVersion,
 	})
+	cfg.UserCache.Invalidate(userID)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Failed to update user")
+		if err != sql.ErrNoRows {
+			respondWithErrorCode(w, http.StatusInternalServerError, codeInternalError, "Failed to update user")
+			return
+		}
+
+		// No row matched: either the version is stale or the user is gone
+		_, lookupErr := cfg.DB.GetUserByID(r.Context(), userID)
+		if lookupErr == nil {
+			respondWithErrorCode(w, http.StatusConflict, codeVersionConflict, "User was modified by another request")
+			return
+		}
+		// The JWT may outlive the account it was issued for
+		if lookupErr == sql.ErrNoRows {
+			respondWithErrorCode(w, http.StatusUnauthorized, codeUserDeleted, "User no longer exists")
+			return
+		}
+		respondWithErrorCode(w, http.StatusInternalServerError, codeInternalError, "Failed to update user")
 		return
 	}
 
 	// 5. Respond with the updated user resource (without the password)
-	user := User{
-		ID:          updatedUser.ID,
-		CreatedAt:   updatedUser.CreatedAt,
-		UpdatedAt:   updatedUser.UpdatedAt,
-		Email:       updatedUser.Email,
-		IsChirpyRed: updatedUser.IsChirpyRed,
-	}
+	user := databaseUserToUser(updatedUser)
 
+	w.Header().Set("ETag", userETag(updatedUser.Version))
 	respondWithJSON(w, http.StatusOK, user)
 }
 
+// meHandler returns the user the request's JWT was issued for.
+func (cfg *apiConfig) meHandler(w http.ResponseWriter, r *http.Request) {
+	tokenString, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithErrorCode(w, http.StatusUnauthorized, codeMissingToken, "Couldn't find JWT")
+		return
+	}
+
+	userID, err := cfg.JWTKeys.ValidateJWT(tokenString)
+	if err != nil {
+		respondWithErrorCode(w, http.StatusUnauthorized, codeInvalidToken, "Invalid JWT")
+		return
+	}
+
+	dbUser, err := cfg.DB.GetUserByID(r.Context(), userID)
+	if err != nil {
+		// The JWT may outlive the account it was issued for
+		if err == sql.ErrNoRows {
+			respondWithErrorCode(w, http.StatusNotFound, codeUserNotFound, "User not found")
+			return
+		}
+		respondWithErrorCode(w, http.StatusInternalServerError, codeInternalError, "Failed to retrieve user")
+		return
+	}
+
+	w.Header().Set("ETag", userETag(dbUser.Version))
+	respondWithJSON(w, http.StatusOK, databaseUserToUser(dbUser))
+}
+
+// userETag formats a user's version as a strong ETag.
+func userETag(version int32) string {
+	return `"` + strconv.Itoa(int(version)) + `"`
+}
+
+// chirpETag returns a strong ETag for one representation of chirp. It changes
+// whenever the chirp is updated, and differs between escaped and raw bodies
+// and, with an embedded author, whenever that author's profile changes.
+func chirpETag(chirp Chirp, escapeHTML bool) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%s|%d|%t", chirp.ID, chirp.UpdatedAt.UnixNano(), escapeHTML)
+	if chirp.Author != nil {
+		fmt.Fprintf(h, "|%s", chirp.Author.etagKey())
+	}
+	// A quoted chirp disappearing changes the response without touching this one
+	if chirp.QuotedChirpUnavailable {
+		fmt.Fprint(h, "|quote-unavailable")
+	}
+	if chirp.QuotedChirp != nil && chirp.QuotedChirp.Author != nil {
+		fmt.Fprintf(h, "|%s", chirp.QuotedChirp.Author.etagKey())
+	}
+	return `"` + hex.EncodeToString(h.Sum(nil)[:16]) + `"`
+}
+
+// etagMatches reports whether an If-None-Match header matches etag. The header
+// may list several tags or be "*"; weak and strong forms compare equal, as
+// RFC 9110 requires for If-None-Match.
+func etagMatches(header, etag string) bool {
+	etag = strings.TrimPrefix(etag, "W/")
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// parseIfMatchVersion extracts the expected user version from an If-Match header.
+// An empty header or "*" means any version is acceptable.
+func parseIfMatchVersion(header string) (sql.NullInt32, error) {
+	header = strings.TrimSpace(header)
+	if header == "" || header == "*" {
+		return sql.NullInt32{}, nil
+	}
+
+	header = strings.Trim(strings.TrimPrefix(header, "W/"), `"`)
+	version, err := strconv.ParseInt(header, 10, 32)
+	if err != nil {
+		return sql.NullInt32{}, err
+	}
+
+	return sql.NullInt32{Int32: int32(version), Valid: true}, nil
+}
+
+// deleteUserHandler deletes the authenticated user's account.
+func (cfg *apiConfig) deleteUserHandler(w http.ResponseWriter, r *http.Request) {
+	tokenString, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithErrorCode(w, http.StatusUnauthorized, codeMissingToken, "Couldn't find JWT")
+		return
+	}
+
+	userID, err := cfg.JWTKeys.ValidateJWT(tokenString)
+	if err != nil {
+		respondWithErrorCode(w, http.StatusUnauthorized, codeInvalidToken, "Invalid JWT")
+		return
+	}
+
+	// Chirps, refresh tokens, and reset tokens are removed by ON DELETE CASCADE.
+	// Outstanding JWTs stay signed until they expire, so authenticated handlers
+	// check that the user still exists before acting on their behalf.
+	deleted, err := cfg.DB.DeleteUser(r.Context(), userID)
+	cfg.UserCache.Invalidate(userID)
+	if err != nil {
+		respondWithErrorCode(w, http.StatusInternalServerError, codeInternalError, "Failed to delete user")
+		return
+	}
+
+	if deleted == 0 {
+		respondWithErrorCode(w, http.StatusUnauthorized, codeUserDeleted, "User no longer exists")
+		return
+	}
+
+	// Billing is told in the background so a slow endpoint can't hold up the delete
+	cfg.BillingWebhook.NotifyUserDeleted(userID)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
 func (cfg *apiConfig) loginHandler(w http.ResponseWriter, r *http.Request) {
+	if !requireJSONContentType(w, r) {
+		return
+	}
+
 	decoder := json.NewDecoder(r.Body)
 	var reqBody loginBody
 
 	err := decoder.Decode(&reqBody)
 	if err != nil {
-		respondWithError(w, http.StatusBadRequest, "Invalid request payload")
+		respondWithErrorCode(w, http.StatusBadRequest, codeInvalidPayload, "Invalid request payload")
 		return
 	}
 
-	dbUser, err := cfg.DB.GetUserByEmail(r.Context(), reqBody.Email)
-	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Incorrect email or password")
+	dbUser, ok := cfg.checkCredentials(w, r, reqBody.Email, reqBody.Password)
+	if !ok {
 		return
 	}
 
-	// Use bcrypt to check the password hash
-	err = auth.CheckPasswordHash(reqBody.Password, dbUser.HashedPassword)
-	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Incorrect email or password")
+	// Checked after the password so the 403 doesn't reveal which emails are deactivated
+	if !dbUser.Active {
+		respondWithErrorCode(w, http.StatusForbidden, codeAccountDeactivated, "Account deactivated")
 		return
 	}
 
-	// Determine the expiration time
-	expiresIn := time.Hour
+	// Determine the expiration time. Clients may request any positive duration up
+	// to MaxTokenExpiry; anything outside that range is rejected rather than clamped.
+	expiresIn := cfg.AccessTokenTTL
 	if reqBody.ExpiresInSeconds != nil {
-		if *reqBody.ExpiresInSeconds <= 3600 {
-			expiresIn = time.Duration(*reqBody.ExpiresInSeconds) * time.Second
+		maxSeconds := int(cfg.MaxTokenExpiry.Seconds())
+		if *reqBody.ExpiresInSeconds <= 0 {
+			respondWithErrorCode(w, http.StatusUnprocessableEntity, codeInvalidParameter, "expires_in_seconds must be positive")
+			return
+		}
+		if *reqBody.ExpiresInSeconds > maxSeconds {
+			respondWithErrorCode(w, http.StatusUnprocessableEntity, codeInvalidParameter, fmt.Sprintf("expires_in_seconds must not exceed %d", maxSeconds))
+			return
 		}
+		expiresIn = time.Duration(*reqBody.ExpiresInSeconds) * time.Second
 	}
 
 	// Create the JWT
-	jwtString, err := auth.MakeJWT(dbUser.ID, cfg.JWTSecret, expiresIn)
+	tokenExpiresAt := time.Now().UTC().Add(expiresIn)
+	jwtString, err := cfg.JWTKeys.MakeJWT(dbUser.ID, expiresIn)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Failed to create JWT")
+		respondWithErrorCode(w, http.StatusInternalServerError, codeInternalError, "Failed to create JWT")
 		return
 	}
 
 	// Create Refresh Token with 60-day expiration
 	refreshToken, err := auth.MakeRefreshToken()
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Failed to create refresh token")
+		respondWithErrorCode(w, http.StatusInternalServerError, codeInternalError, "Failed to create refresh token")
 		return
 	}
 
-	refreshTokenExpiresAt := time.Now().UTC().Add(time.Hour * 24 * 60)
+	refreshTokenExpiresAt := time.Now().UTC().Add(refreshTokenTTL)
 	now := time.Now().UTC()
 
-	// Store the refresh token in the database
-	_, err = cfg.DB.CreateRefreshToken(r.Context(), database.CreateRefreshTokenParams{
-		Token:     refreshToken,
-		CreatedAt: now,
-		UpdatedAt: now,
-		UserID:    dbUser.ID,
-		ExpiresAt: refreshTokenExpiresAt,
+	// Re-read the user and store the refresh token together, so we never hand
+	// out a token for an account that changed or vanished mid-login
+	err = cfg.withTx(r.Context(), func(q *database.Queries) error {
+		var txErr error
+		dbUser, txErr = q.GetUserByID(r.Context(), dbUser.ID)
+		if txErr != nil {
+			return txErr
+		}
+
+		_, txErr = q.CreateRefreshToken(r.Context(), database.CreateRefreshTokenParams{
+			Token:     refreshToken,
+			CreatedAt: now,
+			UpdatedAt: now,
+			UserID:    dbUser.ID,
+			ExpiresAt: refreshTokenExpiresAt,
+			// Each login starts a new family for its chain of rotated tokens
+			FamilyID: uuid.New(),
+		})
+		return txErr
 	})
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Failed to save refresh token")
+		respondWithErrorCode(w, http.StatusInternalServerError, codeInternalError, "Failed to save refresh token")
 		return
 	}
 
 	userWithTokens := UserWithTokens{
-		ID:           dbUser.ID,
-		CreatedAt:    dbUser.CreatedAt,
-		UpdatedAt:    dbUser.UpdatedAt,
-		Email:        dbUser.Email,
-		IsChirpyRed:  dbUser.IsChirpyRed,
+		User:         databaseUserToUser(dbUser),
 		Token:        jwtString,
 		RefreshToken: refreshToken,
+
+		TokenExpiresAt:        tokenExpiresAt,
+		RefreshTokenExpiresAt: refreshTokenExpiresAt,
+		ExpiresIn:             int64(expiresIn.Seconds()),
 	}
 
 	respondWithJSON(w, http.StatusOK, userWithTokens)
 }
 
-// refreshHandler generates a new access token from a valid refresh token.
+// refreshHandler exchanges a refresh token for a new access token and a new
+// refresh token. Each refresh token works once; presenting one that was already
+// rotated means it leaked, so every token descended from the same login is revoked.
 func (cfg *apiConfig) refreshHandler(w http.ResponseWriter, r *http.Request) {
 	tokenString, err := auth.GetBearerToken(r.Header)
 	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Couldn't find refresh token in headers")
+		respondWithErrorCode(w, http.StatusUnauthorized, codeMissingToken, "Couldn't find refresh token in headers")
 		return
 	}
 
-	dbUser, err := cfg.DB.GetUserFromRefreshToken(r.Context(), tokenString)
+	newRefreshToken, err := auth.MakeRefreshToken()
 	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Invalid, expired, or revoked refresh token")
+		respondWithErrorCode(w, http.StatusInternalServerError, codeInternalError, "Failed to create refresh token")
 		return
 	}
 
-	// Create a new JWT with a 1-hour expiration
-	newJWT, err := auth.MakeJWT(dbUser.ID, cfg.JWTSecret, time.Hour)
+	now := time.Now().UTC()
+	refreshTokenExpiresAt := now.Add(refreshTokenTTL)
+
+	var userID uuid.UUID
+	err = cfg.withTx(r.Context(), func(q *database.Queries) error {
+		oldToken, txErr := q.RotateRefreshToken(r.Context(), tokenString)
+		if txErr != nil {
+			return txErr
+		}
+		userID = oldToken.UserID
+
+		_, txErr = q.CreateRefreshToken(r.Context(), database.CreateRefreshTokenParams{
+			Token:     newRefreshToken,
+			CreatedAt: now,
+			UpdatedAt: now,
+			UserID:    oldToken.UserID,
+			ExpiresAt: refreshTokenExpiresAt,
+			FamilyID:  oldToken.FamilyID,
+		})
+		return txErr
+	})
+	if err != nil {
+		// Only a missing row means a bad token; anything else is a database
+		// problem and must not be passed off as a 401
+		if err != sql.ErrNoRows {
+			slog.Error("Failed to rotate refresh token", "request_id", requestIDFromContext(r.Context()), "error", err)
+			respondWithErrorCode(w, http.StatusInternalServerError, codeInternalError, "Failed to rotate refresh token")
+			return
+		}
+
+		cfg.revokeFamilyIfReused(r, tokenString)
+		respondWithErrorCode(w, http.StatusUnauthorized, codeInvalidToken, "Invalid, expired, or revoked refresh token")
+		return
+	}
+
+	tokenExpiresAt := time.Now().UTC().Add(cfg.AccessTokenTTL)
+	newJWT, err := cfg.JWTKeys.MakeJWT(userID, cfg.AccessTokenTTL)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Failed to create new JWT")
+		respondWithErrorCode(w, http.StatusInternalServerError, codeInternalError, "Failed to create new JWT")
 		return
 	}
 
-	// Respond with the new access token
+	// Respond with the new access token and its replacement refresh token
 	response := struct {
-		Token string `json:"token"`
+		Token                 string    `json:"token"`
+		TokenExpiresAt        time.Time `json:"token_expires_at"`
+		RefreshToken          string    `json:"refresh_token"`
+		RefreshTokenExpiresAt time.Time `json:"refresh_token_expires_at"`
+		ExpiresIn             int64     `json:"expires_in"`
 	}{
-		Token: newJWT,
+		Token:                 newJWT,
+		TokenExpiresAt:        tokenExpiresAt,
+		RefreshToken:          newRefreshToken,
+		RefreshTokenExpiresAt: refreshTokenExpiresAt,
+		ExpiresIn:             int64(cfg.AccessTokenTTL.Seconds()),
 	}
 	respondWithJSON(w, http.StatusOK, response)
 }
 
+// revokeFamilyIfReused revokes the whole token family when a rejected refresh
+// token turns out to be one that was already rotated.
+func (cfg *apiConfig) revokeFamilyIfReused(r *http.Request, tokenString string) {
+	dbToken, err := cfg.DB.GetRefreshToken(r.Context(), tokenString)
+	if err != nil || !dbToken.RotatedAt.Valid {
+		// Unknown, expired, or explicitly revoked tokens aren't a reuse signal
+		return
+	}
+
+	err = cfg.DB.RevokeRefreshTokenFamily(r.Context(), dbToken.FamilyID)
+	if err != nil {
+		slog.Error("Failed to revoke reused refresh token family", "request_id", requestIDFromContext(r.Context()), "user_id", dbToken.UserID, "error", err)
+		return
+	}
+
+	slog.Warn("Refresh token reuse detected; revoked token family",
+		"request_id", requestIDFromContext(r.Context()),
+		"user_id", dbToken.UserID,
+		"family_id", dbToken.FamilyID,
+		"remote_addr", r.RemoteAddr,
+	)
+}
+
 // revokeHandler revokes a refresh token.
 func (cfg *apiConfig) revokeHandler(w http.ResponseWriter, r *http.Request) {
 	tokenString, err := auth.GetBearerToken(r.Header)
 	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Couldn't find refresh token in headers")
+		respondWithErrorCode(w, http.StatusUnauthorized, codeMissingToken, "Couldn't find refresh token in headers")
 		return
 	}
 
 	// Revoke the token in the database
 	err = cfg.DB.RevokeRefreshToken(r.Context(), tokenString)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Failed to revoke token")
+		respondWithErrorCode(w, http.StatusInternalServerError, codeInternalError, "Failed to revoke token")
+		return
+	}
+
+	// Clients that ask for JSON learn how many other sessions are still signed in;
+	// everyone else keeps the original empty 204
+	if strings.Contains(r.Header.Get("Accept"), "application/json") {
+		remaining, err := cfg.DB.CountActiveRefreshTokensForTokenOwner(r.Context(), tokenString)
+		if err != nil {
+			respondWithErrorCode(w, http.StatusInternalServerError, codeInternalError, "Failed to count sessions")
+			return
+		}
+
+		respondWithJSON(w, http.StatusOK, revokeResponse{
+			RemainingSessions: remaining,
+		})
 		return
 	}
 
@@ -398,145 +923,803 @@ func (cfg *apiConfig) createChirpHandler(w http.ResponseWriter, r *http.Request)
 	// 1. Get and validate JWT
 	tokenString, err := auth.GetBearerToken(r.Header)
 	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT")
+		respondWithErrorCode(w, http.StatusUnauthorized, codeMissingToken, "Couldn't find JWT")
 		return
 	}
 
-	userID, err := auth.ValidateJWT(tokenString, cfg.JWTSecret)
+	userID, err := cfg.JWTKeys.ValidateJWT(tokenString)
 	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Invalid JWT")
+		respondWithErrorCode(w, http.StatusUnauthorized, codeInvalidToken, "Invalid JWT")
 		return
 	}
 
 	// 2. Decode the request body (chirp content)
-	decoder := json.NewDecoder(r.Body)
-	var reqBody createChirpBody
-
-	err = decoder.Decode(&reqBody)
-	if err != nil {
-		respondWithError(w, http.StatusBadRequest, "Invalid request payload")
+	reqBody, ok := decodeCreateChirpBody(w, r)
+	if !ok {
 		return
 	}
 
 	// 3. Perform length validation and sanitization
-	if len(reqBody.Body) > 140 {
-		respondWithError(w, http.StatusBadRequest, "Chirp is too long")
+	cleanedBody, err := validateChirp(reqBody.Body, cfg.MaxChirpLength, cfg.chirpCensorReplacement(), cfg.ProfanityMatch)
+	if err != nil {
+		respondWithErrorCode(w, http.StatusUnprocessableEntity, chirpErrorCode(err), err.Error())
 		return
 	}
 
-	cleanedBody := sanitizeChirp(reqBody.Body)
-	now := time.Now().UTC()
-	id := uuid.New()
-
-	// 4. Create the chirp in the database using the authenticated user ID
-	dbChirp, err := cfg.DB.CreateChirp(r.Context(), database.CreateChirpParams{
-		ID:        id,
-		CreatedAt: now,
-		UpdatedAt: now,
-		Body:      cleanedBody,
-		UserID:    userID,
-	})
+	visibility, err := parseChirpVisibility(reqBody.Visibility)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Failed to create chirp")
+		respondWithErrorCode(w, http.StatusUnprocessableEntity, chirpErrorCode(err), err.Error())
 		return
 	}
 
-	// Map the database.Chirp to the main package's Chirp struct
-	chirp := Chirp{
-		ID:        dbChirp.ID,
-		CreatedAt: dbChirp.CreatedAt,
-		UpdatedAt: dbChirp.UpdatedAt,
-		Body:      dbChirp.Body,
-		UserID:    dbChirp.UserID,
-	}
-
-	respondWithJSON(w, http.StatusCreated, chirp)
-}
-
-// getChirpsHandler retrieves all chirps from the database.
-func (cfg *apiConfig) getChirpsHandler(w http.ResponseWriter, r *http.Request) {
-	// Check for the optional 'author_id' query parameter
-	authorIDStr := r.URL.Query().Get("author_id")
-
-	var dbChirps []database.Chirp
-	var err error
-
-	if authorIDStr != "" {
-		// Case 1: An author_id is provided, so filter chirps by that ID.
-		authorID, parseErr := uuid.Parse(authorIDStr)
-		if parseErr != nil {
-			respondWithError(w, http.StatusBadRequest, "Invalid author ID")
+	// The JWT may outlive the account it was issued for
+	dbUser, err := cfg.DB.GetUserByID(r.Context(), userID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			respondWithErrorCode(w, http.StatusUnauthorized, codeUserDeleted, "User no longer exists")
 			return
 		}
-		dbChirps, err = cfg.DB.GetChirpsByAuthorID(r.Context(), authorID)
-	} else {
-		// Case 2: No author_id is provided, so return all chirps.
-		dbChirps, err = cfg.DB.GetChirps(r.Context())
+		respondWithErrorCode(w, http.StatusInternalServerError, codeInternalError, "Failed to retrieve user")
+		return
+	}
+	if !dbUser.Active {
+		respondWithErrorCode(w, http.StatusForbidden, codeAccountDeactivated, "Account deactivated")
+		return
 	}
 
-	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Failed to retrieve chirps")
+	// A replayed Idempotency-Key returns the original chirp instead of a duplicate
+	idempotencyKey := r.Header.Get(idempotencyKeyHeader)
+	if len(idempotencyKey) > maxIdempotencyKeyLength {
+		respondWithErrorCode(w, http.StatusBadRequest, codeInvalidParameter, "Idempotency-Key is too long")
 		return
 	}
 
-	// Convert database chirps to the desired output format
-	chirps := []Chirp{}
-	for _, dbChirp := range dbChirps {
-		chirps = append(chirps, Chirp{
-			ID:        dbChirp.ID,
-			CreatedAt: dbChirp.CreatedAt,
-			UpdatedAt: dbChirp.UpdatedAt,
-			Body:      dbChirp.Body,
-			UserID:    dbChirp.UserID,
-		})
+	if idempotencyKey != "" {
+		if cfg.respondWithIdempotentChirp(w, r, userID, idempotencyKey) {
+			return
+		}
 	}
 
-	respondWithJSON(w, http.StatusOK, chirps)
-}
+	// A quote must point at a chirp the author can see. Replays above skip
+	// this, since the quoted chirp may have been deleted in the meantime
+	var quotedChirpID uuid.NullUUID
+	if reqBody.QuotedChirpID != nil {
+		if !cfg.checkQuotedChirps(w, r, []uuid.UUID{*reqBody.QuotedChirpID}) {
+			return
+		}
+		quotedChirpID = uuid.NullUUID{UUID: *reqBody.QuotedChirpID, Valid: true}
+	}
 
-// getChirpHandler retrieves a single chirp by its ID.
-func (cfg *apiConfig) getChirpHandler(w http.ResponseWriter, r *http.Request) {
-	chirpIDStr := r.PathValue("chirpID")
-	chirpID, err := uuid.Parse(chirpIDStr)
+	// Replays are checked first so a retried post never counts against the limit
+	allowed, retryAfter, err := cfg.checkChirpRateLimit(r.Context(), dbUser, 1)
 	if err != nil {
-		respondWithError(w, http.StatusBadRequest, "Invalid chirp ID")
+		respondWithErrorCode(w, http.StatusInternalServerError, codeInternalError, "Failed to check chirp rate limit")
+		return
+	}
+	if !allowed {
+		respondWithRateLimited(w, retryAfter)
 		return
 	}
 
-	dbChirp, err := cfg.DB.GetChirp(r.Context(), chirpID)
+	now := time.Now().UTC()
+	id := newID()
+
+	// 4. Create the chirp and index its hashtags using the authenticated user ID
+	var dbChirp database.Chirp
+	err = cfg.withTx(r.Context(), func(q *database.Queries) error {
+		var txErr error
+		dbChirp, txErr = createChirpWithHashtags(r.Context(), q, database.CreateChirpParams{
+			ID:            id,
+			CreatedAt:     now,
+			UpdatedAt:     now,
+			Body:          cleanedBody,
+			UserID:        userID,
+			QuotedChirpID: quotedChirpID,
+			Visibility:    visibility,
+		})
+		if txErr != nil || idempotencyKey == "" {
+			return txErr
+		}
+		return claimIdempotencyKey(r.Context(), q, userID, idempotencyKey, dbChirp.ID)
+	})
 	if err != nil {
-		// sql.ErrNoRows is returned when the query finds no results.
-		if err == sql.ErrNoRows {
-			respondWithError(w, http.StatusNotFound, "Chirp not found")
+		// A concurrent retry won the race; hand back the chirp it created
+		if errors.Is(err, errIdempotencyKeyTaken) && cfg.respondWithIdempotentChirp(w, r, userID, idempotencyKey) {
 			return
 		}
-		respondWithError(w, http.StatusInternalServerError, "Failed to retrieve chirp")
+		respondWithErrorCode(w, http.StatusInternalServerError, codeInternalError, "Failed to create chirp")
 		return
 	}
 
 	// Map the database.Chirp to the main package's Chirp struct
-	chirp := Chirp{
-		ID:        dbChirp.ID,
-		CreatedAt: dbChirp.CreatedAt,
-		UpdatedAt: dbChirp.UpdatedAt,
-		Body:      dbChirp.Body,
-		UserID:    dbChirp.UserID,
+	chirp := databaseChirpToChirp(dbChirp)
+	chirp.ContainsProfanity = cfg.ProfanityMode == profanityFlag && containsProfanity(chirp.Body, cfg.ProfanityMatch)
+
+	chirps := []Chirp{chirp}
+	err = cfg.embedQuotedChirps(r.Context(), chirps, authorViewer(userID))
+	if err != nil {
+		// The chirp is already saved, so send it without the quote summary
+		slog.Warn("Failed to embed quoted chirp", "request_id", requestIDFromContext(r.Context()), "chirp_id", chirp.ID, "error", err)
 	}
+	chirp = chirps[0]
 
-	respondWithJSON(w, http.StatusOK, chirp)
+	cfg.publishChirp(r.Context(), chirp)
+
+	w.Header().Set("Location", chirpLocation(chirp.ID))
+	respondWithJSON(w, http.StatusCreated, chirp)
+}
+
+// chirpLocation returns the canonical URL path of a chirp.
+func chirpLocation(chirpID uuid.UUID) string {
+	return "/api/chirps/" + chirpID.String()
+}
+
+// respondWithIdempotentChirp writes the chirp previously created with key and
+// reports whether it did. Lookup failures are answered with a 500.
+func (cfg *apiConfig) respondWithIdempotentChirp(w http.ResponseWriter, r *http.Request, userID uuid.UUID, key string) bool {
+	dbChirp, err := cfg.getIdempotentChirp(r.Context(), userID, key)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return false
+		}
+		respondWithErrorCode(w, http.StatusInternalServerError, codeInternalError, "Failed to retrieve chirp")
+		return true
+	}
+
+	chirps := []Chirp{databaseChirpToChirp(dbChirp)}
+	cfg.flagProfanity(chirps)
+	err = cfg.embedQuotedChirps(r.Context(), chirps, authorViewer(userID))
+	if err != nil {
+		respondWithErrorCode(w, http.StatusInternalServerError, codeInternalError, "Failed to retrieve quoted chirp")
+		return true
+	}
+
+	respondWithJSON(w, http.StatusOK, chirps[0])
+	return true
+}
+
+// maxAuthorFilterSize caps how many authors one chirp listing may filter by.
+const maxAuthorFilterSize = 50
+
+// errTooManyAuthors is returned by parseAuthorIDs past maxAuthorFilterSize.
+var errTooManyAuthors = fmt.Errorf("cannot filter by more than %d authors", maxAuthorFilterSize)
+
+// parseAuthorIDs reads the 'author_id' query parameter, which may be repeated
+// or hold a comma-separated list, dropping duplicates.
+func parseAuthorIDs(r *http.Request) ([]uuid.UUID, error) {
+	ids := []uuid.UUID{}
+	seen := map[uuid.UUID]bool{}
+	for _, value := range r.URL.Query()["author_id"] {
+		for _, idStr := range strings.Split(value, ",") {
+			id, err := uuid.Parse(strings.TrimSpace(idStr))
+			if err != nil {
+				return nil, err
+			}
+			if seen[id] {
+				continue
+			}
+			seen[id] = true
+			ids = append(ids, id)
+		}
+	}
+
+	if len(ids) > maxAuthorFilterSize {
+		return nil, errTooManyAuthors
+	}
+	return ids, nil
+}
+
+// parseCountOnly reports whether the request asked for ?count_only=true.
+func parseCountOnly(r *http.Request) (bool, error) {
+	countOnly := r.URL.Query().Get("count_only")
+	switch countOnly {
+	case "", "false":
+		return false, nil
+	case "true":
+		return true, nil
+	default:
+		return false, fmt.Errorf("unsupported count_only value %q", countOnly)
+	}
+}
+
+// parseExpandAuthor reports whether the request asked for ?expand=author.
+func parseExpandAuthor(r *http.Request) (bool, error) {
+	expand := r.URL.Query().Get("expand")
+	switch expand {
+	case "":
+		return false, nil
+	case "author":
+		return true, nil
+	default:
+		return false, fmt.Errorf("unsupported expand value %q", expand)
+	}
+}
+
+// parseEscapeHTML reports whether the request asked for ?escape=html.
+func parseEscapeHTML(r *http.Request) (bool, error) {
+	escape := r.URL.Query().Get("escape")
+	switch escape {
+	case "":
+		return false, nil
+	case "html":
+		return true, nil
+	default:
+		return false, fmt.Errorf("unsupported escape value %q", escape)
+	}
+}
+
+// escapeChirpBodies HTML-escapes each chirp body in place for clients that
+// render bodies as HTML. Only the response changes; stored bodies stay raw.
+func escapeChirpBodies(chirps []Chirp) {
+	for i := range chirps {
+		chirps[i].Body = html.EscapeString(chirps[i].Body)
+		if chirps[i].QuotedChirp != nil {
+			chirps[i].QuotedChirp.Body = html.EscapeString(chirps[i].QuotedChirp.Body)
+		}
+	}
+}
+
+// embedAuthors nests each chirp's public author profile, fetching all authors
+// in one query.
+func (cfg *apiConfig) embedAuthors(ctx context.Context, chirps []Chirp) error {
+	if len(chirps) == 0 {
+		return nil
+	}
+
+	// Serve what we can from the user cache and fetch the rest in one query
+	authors := map[uuid.UUID]*PublicUser{}
+	missingIDs := []uuid.UUID{}
+	var generation uint64
+	for _, chirp := range chirps {
+		if _, seen := authors[chirp.UserID]; seen {
+			continue
+		}
+		authors[chirp.UserID] = nil
+
+		dbUser, gen, ok := cfg.UserCache.get(chirp.UserID)
+		if !ok {
+			// The first miss's generation predates the query, which is all add needs
+			if len(missingIDs) == 0 {
+				generation = gen
+			}
+			missingIDs = append(missingIDs, chirp.UserID)
+			continue
+		}
+		author := databaseUserToPublicUser(dbUser)
+		authors[chirp.UserID] = &author
+	}
+
+	if len(missingIDs) > 0 {
+		dbUsers, err := cfg.DB.GetUsersByIDs(ctx, missingIDs)
+		if err != nil {
+			return err
+		}
+
+		for _, dbUser := range dbUsers {
+			cfg.UserCache.add(dbUser, generation)
+			author := databaseUserToPublicUser(dbUser)
+			authors[dbUser.ID] = &author
+		}
+	}
+
+	for i := range chirps {
+		chirps[i].Author = authors[chirps[i].UserID]
+	}
+
+	return nil
+}
+
+// getChirpsHandler retrieves all chirps from the database.
+func (cfg *apiConfig) getChirpsHandler(w http.ResponseWriter, r *http.Request) {
+	// Check for the optional 'author_id', 'sort', 'since_id', 'expand', 'fields', and 'count_only' query parameters
+	sortStr := r.URL.Query().Get("sort")
+	sinceIDStr := r.URL.Query().Get("since_id")
+
+	expandAuthor, err := parseExpandAuthor(r)
+	if err != nil {
+		respondWithErrorCode(w, http.StatusBadRequest, codeInvalidParameter, "Invalid expand parameter, only 'author' is supported")
+		return
+	}
+
+	escapeHTML, err := parseEscapeHTML(r)
+	if err != nil {
+		respondWithErrorCode(w, http.StatusBadRequest, codeInvalidParameter, "Invalid escape parameter, only 'html' is supported")
+		return
+	}
+
+	fields, err := parseChirpFields(r)
+	if err != nil {
+		respondWithErrorCode(w, http.StatusBadRequest, codeInvalidParameter, "Invalid fields parameter, "+err.Error())
+		return
+	}
+
+	if sortStr != "" && sortStr != "asc" && sortStr != "desc" {
+		respondWithErrorCode(w, http.StatusBadRequest, codeInvalidParameter, "Invalid sort order, must be 'asc' or 'desc'")
+		return
+	}
+	if sortStr == "" {
+		// Deployments can choose timeline order without every client asking for it
+		sortStr = cfg.DefaultChirpOrder
+	}
+	sortDesc := sortStr == "desc"
+
+	// Several authors give a combined timeline, such as for a list of follows
+	authorIDs, err := parseAuthorIDs(r)
+	if err != nil {
+		if errors.Is(err, errTooManyAuthors) {
+			respondWithErrorCode(w, http.StatusBadRequest, codeInvalidParameter, "Cannot filter by more than "+strconv.Itoa(maxAuthorFilterSize)+" authors")
+			return
+		}
+		respondWithErrorCode(w, http.StatusBadRequest, codeInvalidID, "Invalid author ID")
+		return
+	}
+	singleAuthor := len(authorIDs) == 1
+	var authorID uuid.UUID
+	if singleAuthor {
+		authorID = authorIDs[0]
+	}
+
+	viewer, ok := cfg.requestViewer(w, r)
+	if !ok {
+		return
+	}
+
+	// Pollers pass the newest chirp they've seen to get only chirps after it
+	var since *database.Chirp
+	if sinceIDStr != "" {
+		sinceID, parseErr := uuid.Parse(sinceIDStr)
+		if parseErr != nil {
+			respondWithErrorCode(w, http.StatusBadRequest, codeInvalidID, "Invalid since_id")
+			return
+		}
+
+		sinceChirp, lookupErr := retryRead(r.Context(), func() (database.Chirp, error) {
+			return cfg.DB.GetChirp(r.Context(), database.GetChirpParams{ID: sinceID, ViewerID: viewer.id})
+		})
+		if lookupErr != nil {
+			if lookupErr == sql.ErrNoRows {
+				respondWithErrorCode(w, http.StatusNotFound, codeChirpNotFound, "since_id chirp not found")
+				return
+			}
+			respondWithErrorCode(w, http.StatusInternalServerError, codeInternalError, "Failed to retrieve chirps")
+			return
+		}
+		since = &sinceChirp
+	}
+
+	countOnly, err := parseCountOnly(r)
+	if err != nil {
+		respondWithErrorCode(w, http.StatusBadRequest, codeInvalidParameter, "Invalid count_only parameter, must be 'true' or 'false'")
+		return
+	}
+
+	// Sending 'limit' or 'cursor' opts in to cursor pagination and the page envelope
+	paginate := r.URL.Query().Has("limit") || r.URL.Query().Has("cursor")
+	var limit int32
+	var cursor *chirpCursor
+	if paginate {
+		limit, cursor, err = parseCursorPagination(r)
+		if err != nil {
+			respondWithErrorCode(w, http.StatusBadRequest, codeInvalidParameter, err.Error())
+			return
+		}
+	}
+
+	if countOnly {
+		cfg.respondWithChirpCount(w, r, authorIDs, since, viewer)
+		return
+	}
+
+	// Let Postgres filter and order rather than loading the full table into Go
+	// Reads are safe to repeat, so ride out brief database restarts
+	dbChirps, err := retryRead(r.Context(), func() ([]database.Chirp, error) {
+		switch {
+		case paginate || since != nil || len(authorIDs) > 1 || len(viewer.hidden) > 0:
+			// Case 1: Keyset query for pages, since_id, multiple authors, and
+			// callers with blocks, whose chirps are excluded in SQL.
+			// A page fetches an extra row to tell whether another page follows.
+			params := database.GetChirpsPageParams{
+				SortDesc:        sortDesc,
+				ExcludedUserIds: hiddenAuthorIDs(viewer.hidden),
+				ViewerID:        viewer.id,
+			}
+			if paginate {
+				params.PageLimit = sql.NullInt32{Int32: limit + 1, Valid: true}
+			}
+			if singleAuthor {
+				params.UserID = uuid.NullUUID{UUID: authorID, Valid: true}
+			} else if len(authorIDs) > 1 {
+				params.UserIds = authorIDs
+			}
+			if cursor != nil {
+				params.CursorCreatedAt = sql.NullTime{Time: cursor.CreatedAt, Valid: true}
+				params.CursorID = uuid.NullUUID{UUID: cursor.ID, Valid: true}
+			}
+			if since != nil {
+				params.SinceCreatedAt = sql.NullTime{Time: since.CreatedAt, Valid: true}
+				params.SinceID = uuid.NullUUID{UUID: since.ID, Valid: true}
+			}
+			return cfg.DB.GetChirpsPage(r.Context(), params)
+		case singleAuthor && sortStr != "":
+			// Case 2: Filter by author in the requested order.
+			return cfg.DB.GetChirpsByAuthorIDOrdered(r.Context(), database.GetChirpsByAuthorIDOrderedParams{
+				UserID:   authorID,
+				ViewerID: viewer.id,
+				SortDesc: sortDesc,
+			})
+		case singleAuthor:
+			// Case 3: Filter by author in the default order.
+			return cfg.DB.GetChirpsByAuthorID(r.Context(), database.GetChirpsByAuthorIDParams{
+				UserID:   authorID,
+				ViewerID: viewer.id,
+			})
+		case sortStr != "":
+			// Case 4: All chirps in the requested order.
+			return cfg.DB.GetChirpsOrdered(r.Context(), database.GetChirpsOrderedParams{
+				ViewerID: viewer.id,
+				SortDesc: sortDesc,
+			})
+		default:
+			// Case 5: No parameters, so return all chirps.
+			return cfg.DB.GetChirps(r.Context(), viewer.id)
+		}
+	})
+
+	if err != nil {
+		respondWithErrorCode(w, http.StatusInternalServerError, codeInternalError, "Failed to retrieve chirps")
+		return
+	}
+
+	var nextCursor *string
+	if paginate && len(dbChirps) > int(limit) {
+		dbChirps = dbChirps[:limit]
+		last := dbChirps[len(dbChirps)-1]
+		encoded := encodeChirpCursor(last.CreatedAt, last.ID)
+		nextCursor = &encoded
+	}
+
+	// Convert database chirps to the desired output format
+	chirps := []Chirp{}
+	for _, dbChirp := range dbChirps {
+		chirps = append(chirps, databaseChirpToChirp(dbChirp))
+	}
+
+	// Mark the author's pinned chirp so profile views can render it first
+	if singleAuthor {
+		author, lookupErr := cfg.cachedUserByID(r.Context(), authorID)
+		if lookupErr != nil && lookupErr != sql.ErrNoRows {
+			respondWithErrorCode(w, http.StatusInternalServerError, codeInternalError, "Failed to retrieve chirp author")
+			return
+		}
+		if lookupErr == nil && author.PinnedChirpID.Valid {
+			for i := range chirps {
+				chirps[i].Pinned = chirps[i].ID == author.PinnedChirpID.UUID
+			}
+		}
+	}
+
+	if expandAuthor {
+		err = cfg.embedAuthors(r.Context(), chirps)
+		if err != nil {
+			respondWithErrorCode(w, http.StatusInternalServerError, codeInternalError, "Failed to retrieve chirp authors")
+			return
+		}
+	}
+
+	err = cfg.embedQuotedChirps(r.Context(), chirps, viewer)
+	if err != nil {
+		respondWithErrorCode(w, http.StatusInternalServerError, codeInternalError, "Failed to retrieve quoted chirps")
+		return
+	}
+
+	cfg.flagProfanity(chirps)
+	if escapeHTML {
+		escapeChirpBodies(chirps)
+	}
+
+	selected, err := fields.selectAll(chirps)
+	if err != nil {
+		respondWithErrorCode(w, http.StatusInternalServerError, codeInternalError, "Failed to encode chirps")
+		return
+	}
+
+	if paginate {
+		respondWithJSON(w, http.StatusOK, chirpsPage{
+			Chirps:     selected,
+			NextCursor: nextCursor,
+		})
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, selected)
+}
+
+// chirpCount is the response to GET /api/chirps?count_only=true.
+type chirpCount struct {
+	Count int64 `json:"count"`
+}
+
+// respondWithChirpCount counts the chirps getChirpsHandler would list for the
+// same authors and since_id, without fetching them. Sorting and pagination
+// don't change the total, so they're ignored.
+func (cfg *apiConfig) respondWithChirpCount(w http.ResponseWriter, r *http.Request, authorIDs []uuid.UUID, since *database.Chirp, viewer chirpViewer) {
+	params := database.CountChirpsMatchingParams{
+		ExcludedUserIds: hiddenAuthorIDs(viewer.hidden),
+		ViewerID:        viewer.id,
+	}
+	if len(authorIDs) > 0 {
+		params.UserIds = authorIDs
+	}
+	if since != nil {
+		params.SinceCreatedAt = sql.NullTime{Time: since.CreatedAt, Valid: true}
+		params.SinceID = uuid.NullUUID{UUID: since.ID, Valid: true}
+	}
+
+	count, err := retryRead(r.Context(), func() (int64, error) {
+		return cfg.DB.CountChirpsMatching(r.Context(), params)
+	})
+	if err != nil {
+		respondWithErrorCode(w, http.StatusInternalServerError, codeInternalError, "Failed to count chirps")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, chirpCount{Count: count})
+}
+
+// getChirpHandler retrieves a single chirp by its ID.
+func (cfg *apiConfig) getChirpHandler(w http.ResponseWriter, r *http.Request) {
+	chirpIDStr := r.PathValue("chirpID")
+	chirpID, err := uuid.Parse(chirpIDStr)
+	if err != nil {
+		respondWithErrorCode(w, http.StatusBadRequest, codeInvalidID, "Invalid chirp ID")
+		return
+	}
+
+	expandAuthor, err := parseExpandAuthor(r)
+	if err != nil {
+		respondWithErrorCode(w, http.StatusBadRequest, codeInvalidParameter, "Invalid expand parameter, only 'author' is supported")
+		return
+	}
+
+	escapeHTML, err := parseEscapeHTML(r)
+	if err != nil {
+		respondWithErrorCode(w, http.StatusBadRequest, codeInvalidParameter, "Invalid escape parameter, only 'html' is supported")
+		return
+	}
+
+	fields, err := parseChirpFields(r)
+	if err != nil {
+		respondWithErrorCode(w, http.StatusBadRequest, codeInvalidParameter, "Invalid fields parameter, "+err.Error())
+		return
+	}
+
+	viewer, ok := cfg.requestViewer(w, r)
+	if !ok {
+		return
+	}
+
+	// Other users' private chirps aren't found, as if they didn't exist
+	dbChirp, err := retryRead(r.Context(), func() (database.Chirp, error) {
+		return cfg.DB.GetChirp(r.Context(), database.GetChirpParams{ID: chirpID, ViewerID: viewer.id})
+	})
+	if err != nil {
+		// sql.ErrNoRows is returned when the query finds no results.
+		if err == sql.ErrNoRows {
+			respondWithErrorCode(w, http.StatusNotFound, codeChirpNotFound, "Chirp not found")
+			return
+		}
+		respondWithErrorCode(w, http.StatusInternalServerError, codeInternalError, "Failed to retrieve chirp")
+		return
+	}
+
+	// Blocked users' chirps look the same as missing ones
+	if !viewer.canSee(dbChirp.UserID, dbChirp.Visibility) {
+		respondWithErrorCode(w, http.StatusNotFound, codeChirpNotFound, "Chirp not found")
+		return
+	}
+
+	// Map the database.Chirp to the main package's Chirp struct
+	chirps := []Chirp{databaseChirpToChirp(dbChirp)}
+
+	if expandAuthor {
+		err = cfg.embedAuthors(r.Context(), chirps)
+		if err != nil {
+			respondWithErrorCode(w, http.StatusInternalServerError, codeInternalError, "Failed to retrieve chirp author")
+			return
+		}
+	}
+
+	err = cfg.embedQuotedChirps(r.Context(), chirps, viewer)
+	if err != nil {
+		respondWithErrorCode(w, http.StatusInternalServerError, codeInternalError, "Failed to retrieve quoted chirp")
+		return
+	}
+
+	cfg.flagProfanity(chirps)
+	if escapeHTML {
+		escapeChirpBodies(chirps)
+	}
+	chirp := chirps[0]
+
+	// Clients re-fetching a chirp they already have get a bodiless 304
+	etag := fields.etag(chirpETag(chirp, escapeHTML))
+	w.Header().Set("ETag", etag)
+	if etagMatches(r.Header.Get("If-None-Match"), etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	selected, err := fields.selectOne(chirp)
+	if err != nil {
+		respondWithErrorCode(w, http.StatusInternalServerError, codeInternalError, "Failed to encode chirp")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, selected)
+}
+
+// getLatestChirpHandler returns only the newest chirp, for the homepage,
+// without paging through the timeline.
+func (cfg *apiConfig) getLatestChirpHandler(w http.ResponseWriter, r *http.Request) {
+	escapeHTML, err := parseEscapeHTML(r)
+	if err != nil {
+		respondWithErrorCode(w, http.StatusBadRequest, codeInvalidParameter, "Invalid escape parameter, only 'html' is supported")
+		return
+	}
+
+	viewer, ok := cfg.requestViewer(w, r)
+	if !ok {
+		return
+	}
+
+	// Chirps the viewer can't see are skipped in the query so the next newest
+	// chirp is found
+	dbChirp, err := retryRead(r.Context(), func() (database.Chirp, error) {
+		return cfg.DB.GetLatestChirp(r.Context(), database.GetLatestChirpParams{
+			ExcludedUserIds: hiddenAuthorIDs(viewer.hidden),
+			ViewerID:        viewer.id,
+		})
+	})
+	if err != nil {
+		if err == sql.ErrNoRows {
+			respondWithErrorCode(w, http.StatusNotFound, codeChirpNotFound, "No chirps yet")
+			return
+		}
+		respondWithErrorCode(w, http.StatusInternalServerError, codeInternalError, "Failed to retrieve chirp")
+		return
+	}
+
+	chirps := []Chirp{databaseChirpToChirp(dbChirp)}
+	err = cfg.embedQuotedChirps(r.Context(), chirps, viewer)
+	if err != nil {
+		respondWithErrorCode(w, http.StatusInternalServerError, codeInternalError, "Failed to retrieve quoted chirp")
+		return
+	}
+
+	cfg.flagProfanity(chirps)
+	if escapeHTML {
+		escapeChirpBodies(chirps)
+	}
+
+	respondWithJSON(w, http.StatusOK, chirps[0])
+}
+
+// headChirpHandler reports whether a chirp exists without sending it.
+// Responses carry the headers a GET would, but never a body.
+func (cfg *apiConfig) headChirpHandler(w http.ResponseWriter, r *http.Request) {
+	chirpID, err := uuid.Parse(r.PathValue("chirpID"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	// net/http drops any error body requestViewer writes for HEAD
+	viewer, ok := cfg.requestViewer(w, r)
+	if !ok {
+		return
+	}
+
+	dbChirp, err := retryRead(r.Context(), func() (database.Chirp, error) {
+		return cfg.DB.GetChirp(r.Context(), database.GetChirpParams{ID: chirpID, ViewerID: viewer.id})
+	})
+	if err != nil {
+		if err == sql.ErrNoRows {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	// Blocked users' chirps look the same as missing ones, as with GET
+	if !viewer.canSee(dbChirp.UserID, dbChirp.Visibility) {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	chirps := []Chirp{databaseChirpToChirp(dbChirp)}
+	err = cfg.embedQuotedChirps(r.Context(), chirps, viewer)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	chirp := chirps[0]
+
+	etag := chirpETag(chirp, false)
+	w.Header().Set("ETag", etag)
+	if etagMatches(r.Header.Get("If-None-Match"), etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	// Content-Length is the size of the body GET would return
+	dat, err := json.Marshal(chirp)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Length", strconv.Itoa(len(dat)))
+	w.WriteHeader(http.StatusOK)
+}
+
+// getChirpAuthorHandler retrieves the public profile of a chirp's author.
+func (cfg *apiConfig) getChirpAuthorHandler(w http.ResponseWriter, r *http.Request) {
+	chirpIDStr := r.PathValue("chirpID")
+	chirpID, err := uuid.Parse(chirpIDStr)
+	if err != nil {
+		respondWithErrorCode(w, http.StatusBadRequest, codeInvalidID, "Invalid chirp ID")
+		return
+	}
+
+	viewer, ok := cfg.requestViewer(w, r)
+	if !ok {
+		return
+	}
+
+	dbChirp, err := retryRead(r.Context(), func() (database.Chirp, error) {
+		return cfg.DB.GetChirp(r.Context(), database.GetChirpParams{ID: chirpID, ViewerID: viewer.id})
+	})
+	if err != nil {
+		if err == sql.ErrNoRows {
+			respondWithErrorCode(w, http.StatusNotFound, codeChirpNotFound, "Chirp not found")
+			return
+		}
+		respondWithErrorCode(w, http.StatusInternalServerError, codeInternalError, "Failed to retrieve chirp")
+		return
+	}
+
+	// A chirp the viewer can't read doesn't reveal who wrote it
+	if !viewer.canSee(dbChirp.UserID, dbChirp.Visibility) {
+		respondWithErrorCode(w, http.StatusNotFound, codeChirpNotFound, "Chirp not found")
+		return
+	}
+
+	dbUser, err := cfg.cachedUserByID(r.Context(), dbChirp.UserID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			respondWithErrorCode(w, http.StatusNotFound, codeUserNotFound, "Author not found")
+			return
+		}
+		respondWithErrorCode(w, http.StatusInternalServerError, codeInternalError, "Failed to retrieve author")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, databaseUserToPublicUser(dbUser))
 }
 
 func (cfg *apiConfig) deleteChirpHandler(w http.ResponseWriter, r *http.Request) {
 	// 1. Authenticate the user with the JWT
 	tokenString, err := auth.GetBearerToken(r.Header)
 	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT")
+		respondWithErrorCode(w, http.StatusUnauthorized, codeMissingToken, "Couldn't find JWT")
 		return
 	}
 
-	authenticatedUserID, err := auth.ValidateJWT(tokenString, cfg.JWTSecret)
+	authenticatedUserID, err := cfg.JWTKeys.ValidateJWT(tokenString)
 	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Invalid JWT")
+		respondWithErrorCode(w, http.StatusUnauthorized, codeInvalidToken, "Invalid JWT")
 		return
 	}
 
@@ -544,7 +1727,7 @@ func (cfg *apiConfig) deleteChirpHandler(w http.ResponseWriter, r *http.Request)
 	chirpIDStr := r.PathValue("chirpID")
 	chirpID, err := uuid.Parse(chirpIDStr)
 	if err != nil {
-		respondWithError(w, http.StatusBadRequest, "Invalid chirp ID")
+		respondWithErrorCode(w, http.StatusBadRequest, codeInvalidID, "Invalid chirp ID")
 		return
 	}
 
@@ -552,26 +1735,38 @@ func (cfg *apiConfig) deleteChirpHandler(w http.ResponseWriter, r *http.Request)
 	dbChirp, err := cfg.DB.GetChirpForDeletion(r.Context(), chirpID)
 	if err != nil {
 		if err == sql.ErrNoRows {
-			respondWithError(w, http.StatusNotFound, "Chirp not found")
+			respondWithErrorCode(w, http.StatusNotFound, codeChirpNotFound, "Chirp not found")
 			return
 		}
-		respondWithError(w, http.StatusInternalServerError, "Failed to retrieve chirp")
+		respondWithErrorCode(w, http.StatusInternalServerError, codeInternalError, "Failed to retrieve chirp")
 		return
 	}
 
 	// 4. Check if the authenticated user is the owner of the chirp
 	if dbChirp.UserID != authenticatedUserID {
-		respondWithError(w, http.StatusForbidden, "You do not have permission to delete this chirp")
+		respondWithErrorCode(w, http.StatusForbidden, codeForbidden, "You do not have permission to delete this chirp")
 		return
 	}
 
-	// 5. Delete the chirp
-	err = cfg.DB.DeleteChirp(r.Context(), database.DeleteChirpParams{
-		ID:     chirpID,
-		UserID: authenticatedUserID,
+	// 5. Unpin the chirp, delete it, and archive any reports against it
+	err = cfg.withTx(r.Context(), func(q *database.Queries) error {
+		txErr := q.UnpinDeletedChirp(r.Context(), uuid.NullUUID{UUID: chirpID, Valid: true})
+		if txErr != nil {
+			return txErr
+		}
+		txErr = q.DeleteChirp(r.Context(), database.DeleteChirpParams{
+			ID:     chirpID,
+			UserID: authenticatedUserID,
+		})
+		if txErr != nil {
+			return txErr
+		}
+		return q.ArchiveChirpReports(r.Context(), chirpID)
 	})
+	// Only the author can have pinned it, so theirs is the only cached user that changed
+	cfg.UserCache.Invalidate(authenticatedUserID)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Failed to delete chirp")
+		respondWithErrorCode(w, http.StatusInternalServerError, codeInternalError, "Failed to delete chirp")
 		return
 	}
 
@@ -579,16 +1774,64 @@ func (cfg *apiConfig) deleteChirpHandler(w http.ResponseWriter, r *http.Request)
 	w.WriteHeader(http.StatusNoContent)
 }
 
-// respondWithError is a helper function to send JSON error responses.
-func respondWithError(w http.ResponseWriter, code int, msg string) {
-	respondWithJSON(w, code, errorResponse{Error: msg})
+// decodeCreateChirpBody reads a new chirp from either a JSON or an
+// application/x-www-form-urlencoded body, so plain HTML forms can post too.
+// It writes an error response and returns false if the body can't be read.
+func decodeCreateChirpBody(w http.ResponseWriter, r *http.Request) (createChirpBody, bool) {
+	var reqBody createChirpBody
+
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	switch mediaType {
+	case "application/json":
+		err := json.NewDecoder(r.Body).Decode(&reqBody)
+		if err != nil {
+			respondWithErrorCode(w, http.StatusBadRequest, codeInvalidPayload, "Invalid request payload")
+			return createChirpBody{}, false
+		}
+	case "application/x-www-form-urlencoded":
+		err := r.ParseForm()
+		if err != nil {
+			respondWithErrorCode(w, http.StatusBadRequest, codeInvalidPayload, "Invalid form body")
+			return createChirpBody{}, false
+		}
+		reqBody.Body = r.PostForm.Get("body")
+		reqBody.Visibility = r.PostForm.Get("visibility")
+		if quoted := r.PostForm.Get("quoted_chirp_id"); quoted != "" {
+			quotedID, err := uuid.Parse(quoted)
+			if err != nil {
+				respondWithErrorCode(w, http.StatusBadRequest, codeInvalidID, "Invalid quoted chirp ID")
+				return createChirpBody{}, false
+			}
+			reqBody.QuotedChirpID = &quotedID
+		}
+	default:
+		respondWithErrorCode(w, http.StatusUnsupportedMediaType, codeUnsupportedMediaType, "Content-Type must be application/json or application/x-www-form-urlencoded")
+		return createChirpBody{}, false
+	}
+
+	return reqBody, true
+}
+
+// requireJSONContentType writes a 415 and returns false unless the request body is JSON.
+func requireJSONContentType(w http.ResponseWriter, r *http.Request) bool {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil || mediaType != "application/json" {
+		respondWithErrorCode(w, http.StatusUnsupportedMediaType, codeUnsupportedMediaType, "Content-Type must be application/json")
+		return false
+	}
+	return true
+}
+
+// respondWithErrorCode sends a JSON error response carrying a machine-readable error code.
+func respondWithErrorCode(w http.ResponseWriter, status int, errCode, msg string) {
+	respondWithJSON(w, status, errorResponse{Error: msg, Code: errCode})
 }
 
 // respondWithJSON is a helper function to send a JSON response.
 func respondWithJSON(w http.ResponseWriter, code int, payload any) {
 	dat, err := json.Marshal(payload)
 	if err != nil {
-		log.Printf("Error marshalling JSON: %s", err)
+		slog.Error("Error marshalling JSON", "error", err)
 		w.WriteHeader(500)
 		return
 	}
@@ -610,11 +1853,70 @@ func healthzHandler(w http.ResponseWriter, r *http.Request) {
 	w.Write([]byte(http.StatusText(http.StatusOK)))
 }
 
+// intFromEnv reads a positive integer environment variable, falling back to def when unset.
+func intFromEnv(key string, def int) (int, error) {
+	value := os.Getenv(key)
+	if value == "" {
+		return def, nil
+	}
+
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 1 {
+		return 0, fmt.Errorf("%s must be a positive integer", key)
+	}
+
+	return n, nil
+}
+
+// nonNegativeIntFromEnv is intFromEnv for settings where 0 is meaningful, such as turning a feature off.
+func nonNegativeIntFromEnv(key string, def int) (int, error) {
+	value := os.Getenv(key)
+	if value == "" {
+		return def, nil
+	}
+
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("%s must be a non-negative integer", key)
+	}
+
+	return n, nil
+}
+
+// durationFromEnv reads a positive duration environment variable such as "5m", falling back to def when unset.
+func durationFromEnv(key string, def time.Duration) (time.Duration, error) {
+	value := os.Getenv(key)
+	if value == "" {
+		return def, nil
+	}
+
+	d, err := time.ParseDuration(value)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("%s must be a positive duration, e.g. 24h or 5m", key)
+	}
+
+	return d, nil
+}
+
 func main() {
+	// Route both slog and the standard logger through a leveled, structured
+	// handler before anything can fail. The level is set once .env is loaded,
+	// since LOG_LEVEL may come from there.
+	logLevel := new(slog.LevelVar)
+	slog.SetDefault(slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: logLevel})))
+
 	// Load environment variables from .env file
 	err := godotenv.Load()
 	if err != nil {
-		log.Fatal("Error loading .env file")
+		slog.Error("Error loading .env file", "error", err)
+		os.Exit(1)
+	}
+
+	if logLevelStr := os.Getenv("LOG_LEVEL"); logLevelStr != "" {
+		err = logLevel.UnmarshalText([]byte(logLevelStr))
+		if err != nil {
+			log.Fatal("LOG_LEVEL must be one of debug, info, warn, or error")
+		}
 	}
 
 	// Get the DB_URL from environment variables
@@ -628,16 +1930,156 @@ func main() {
 		log.Fatal("PLATFORM must be set")
 	}
 
-	jwtSecret := os.Getenv("JWT_SECRET")
-	if jwtSecret == "" {
-		log.Fatal("JWT_SECRET environment variable is not set")
+	// JWT_SECRETS lists kid:secret pairs, current key first, so the signing
+	// secret can be rotated without invalidating tokens that are still live.
+	// A lone JWT_SECRET signs tokens without a kid, as before.
+	var jwtKeys *auth.KeyRing
+	if jwtSecrets := os.Getenv("JWT_SECRETS"); jwtSecrets != "" {
+		jwtKeys, err = auth.ParseKeyRing(jwtSecrets)
+		if err != nil {
+			log.Fatalf("Invalid JWT_SECRETS: %v", err)
+		}
+	} else {
+		jwtSecret := os.Getenv("JWT_SECRET")
+		if jwtSecret == "" {
+			log.Fatal("JWT_SECRET or JWT_SECRETS must be set")
+		}
+		jwtKeys, err = auth.NewKeyRing("", map[string]string{"": jwtSecret})
+		if err != nil {
+			log.Fatal(err)
+		}
 	}
 
+	// Services that share a secret should use distinct audiences so their tokens
+	// can't be replayed against each other
+	jwtIssuer := os.Getenv("JWT_ISSUER")
+	if jwtIssuer == "" {
+		jwtIssuer = "chirpy"
+	}
+	jwtAudience := os.Getenv("JWT_AUDIENCE")
+	if jwtAudience == "" {
+		jwtAudience = "chirpy-api"
+	}
+	jwtKeys.SetClaims(jwtIssuer, jwtAudience)
+
 	polkaKey := os.Getenv("POLKA_KEY")
 	if polkaKey == "" {
 		log.Fatal("POLKA_KEY must be set")
 	}
 
+	// Upper bound for client-requested access token lifetimes
+	maxTokenExpiry, err := durationFromEnv("MAX_TOKEN_EXPIRY", 24*time.Hour)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	accessTokenTTL, err := durationFromEnv("ACCESS_TOKEN_TTL", time.Hour)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if accessTokenTTL > maxTokenExpiry {
+		log.Fatal("ACCESS_TOKEN_TTL must not exceed MAX_TOKEN_EXPIRY")
+	}
+
+	maxChirpLength, err := intFromEnv("MAX_CHIRP_LENGTH", defaultMaxChirpLength)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	profanityMode, err := parseProfanityMode(os.Getenv("PROFANITY_MODE"))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	profanityMatch, err := parseProfanityMatch(os.Getenv("PROFANITY_MATCH_MODE"))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Set-but-empty would silently delete profane words instead of masking them
+	profanityReplacement := defaultProfanityReplacement
+	if value, ok := os.LookupEnv("PROFANITY_REPLACEMENT"); ok {
+		if value == "" {
+			log.Fatal("PROFANITY_REPLACEMENT must not be empty")
+		}
+		profanityReplacement = value
+	}
+
+	chirpRateLimit, err := intFromEnv("CHIRP_RATE_LIMIT", 30)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	chirpRateLimitRed, err := intFromEnv("CHIRP_RATE_LIMIT_RED", 100)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	userCacheSize, err := intFromEnv("USER_CACHE_SIZE", 1000)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	userCacheTTL, err := durationFromEnv("USER_CACHE_TTL", time.Minute)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// 0 turns lockout off
+	loginLockoutThreshold, err := nonNegativeIntFromEnv("LOGIN_LOCKOUT_THRESHOLD", 5)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	loginLockoutCooldown, err := durationFromEnv("LOGIN_LOCKOUT_COOLDOWN", 15*time.Minute)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	defaultChirpOrder := os.Getenv("DEFAULT_CHIRP_ORDER")
+	if defaultChirpOrder != "" && defaultChirpOrder != "asc" && defaultChirpOrder != "desc" {
+		log.Fatal("DEFAULT_CHIRP_ORDER must be 'asc' or 'desc'")
+	}
+
+	// Outbound webhooks are optional
+	var chirpWebhook *outboundWebhook
+	signingSecret := os.Getenv("WEBHOOK_SIGNING_SECRET")
+	chirpWebhookURL := os.Getenv("CHIRP_WEBHOOK_URL")
+	billingWebhookURL := os.Getenv("BILLING_WEBHOOK_URL")
+	passwordResetWebhookURL := os.Getenv("PASSWORD_RESET_WEBHOOK_URL")
+	if signingSecret == "" && (chirpWebhookURL != "" || billingWebhookURL != "" || passwordResetWebhookURL != "") {
+		slog.Warn("WEBHOOK_SIGNING_SECRET is not set; outbound webhooks will be sent unsigned")
+	}
+	if chirpWebhookURL != "" {
+		chirpWebhook = newOutboundWebhook(chirpWebhookURL, signingSecret)
+	}
+	var billingWebhook *outboundWebhook
+	if billingWebhookURL != "" {
+		billingWebhook = newOutboundWebhook(billingWebhookURL, signingSecret)
+	}
+	var passwordResetWebhook *outboundWebhook
+	if passwordResetWebhookURL != "" {
+		passwordResetWebhook = newOutboundWebhook(passwordResetWebhookURL, signingSecret)
+	} else if platform != "dev" {
+		slog.Warn("PASSWORD_RESET_WEBHOOK_URL is not set; password reset tokens will not be delivered")
+	}
+
+	// Browsers may only call the API cross-origin from allowlisted origins
+	allowCredentials := false
+	if allowCredentialsStr := os.Getenv("CORS_ALLOW_CREDENTIALS"); allowCredentialsStr != "" {
+		allowCredentials, err = strconv.ParseBool(allowCredentialsStr)
+		if err != nil {
+			log.Fatal("CORS_ALLOW_CREDENTIALS must be true or false")
+		}
+	}
+	cors, err := newCORSPolicy(os.Getenv("CORS_ALLOWED_ORIGINS"), allowCredentials)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Admin endpoints stay locked when no admin key is configured
+	adminKey := os.Getenv("ADMIN_API_KEY")
+
 	// Open a connection to the database
 	db, err := sql.Open("postgres", dbURL)
 	if err != nil {
@@ -645,27 +2087,116 @@ func main() {
 	}
 	defer db.Close() // Defer closing the database connection
 
+	// database/sql never limits the pool by default, so an unbounded burst of
+	// requests can exhaust Postgres's connection slots
+	maxOpenConns, err := intFromEnv("DB_MAX_OPEN_CONNS", 25)
+	if err != nil {
+		log.Fatal(err)
+	}
+	maxIdleConns, err := intFromEnv("DB_MAX_IDLE_CONNS", 25)
+	if err != nil {
+		log.Fatal(err)
+	}
+	connMaxLifetime, err := durationFromEnv("DB_CONN_MAX_LIFETIME", 5*time.Minute)
+	if err != nil {
+		log.Fatal(err)
+	}
+	db.SetMaxOpenConns(maxOpenConns)
+	db.SetMaxIdleConns(maxIdleConns)
+	db.SetConnMaxLifetime(connMaxLifetime)
+	slog.Info("Database pool configured",
+		"max_open_conns", maxOpenConns,
+		"max_idle_conns", maxIdleConns,
+		"conn_max_lifetime", connMaxLifetime.String(),
+	)
+
+	// sql.Open doesn't connect, so check now rather than on the first request
+	pingCtx, cancelPing := context.WithTimeout(context.Background(), 5*time.Second)
+	err = db.PingContext(pingCtx)
+	cancelPing()
+	if err != nil {
+		log.Fatalf("Error connecting to the database: %v", err)
+	}
+
 	// Use the SQLC generated database package to create new queries
 	dbQueries := database.New(db)
 
 	mux := http.NewServeMux()
 	apiCfg := &apiConfig{
-		DB:        dbQueries,
-		Platform:  platform,
-		JWTSecret: jwtSecret,
-		PolkaKey:  polkaKey,
+		DB:                   dbQueries,
+		Conn:                 db,
+		Platform:             platform,
+		JWTKeys:              jwtKeys,
+		PolkaKey:             polkaKey,
+		AdminKey:             adminKey,
+		MaxTokenExpiry:       maxTokenExpiry,
+		AccessTokenTTL:       accessTokenTTL,
+		MaxChirpLength:       maxChirpLength,
+		ProfanityMode:        profanityMode,
+		ProfanityReplacement: profanityReplacement,
+		ProfanityMatch:       profanityMatch,
+		ChirpBroker:          newChirpBroker(),
+		ChirpWebhook:         chirpWebhook,
+		BillingWebhook:       billingWebhook,
+		PasswordResetWebhook: passwordResetWebhook,
+		CORS:                 cors,
+		UserCache:            newUserCache(userCacheSize, userCacheTTL),
+		LockoutThreshold:     loginLockoutThreshold,
+		LockoutCooldown:      loginLockoutCooldown,
+		DefaultChirpOrder:    defaultChirpOrder,
+		ChirpRateLimit:       chirpRateLimit,
+		ChirpRateLimitRed:    chirpRateLimitRed,
 	}
 
+	// Expired idempotency keys and webhook event IDs are swept in the background
+	go apiCfg.cleanupIdempotencyKeys(time.Hour)
+	go apiCfg.cleanupWebhookEvents(time.Hour)
+	go apiCfg.cleanupLoginFailures(time.Hour)
+
 	// API endpoints
 	mux.HandleFunc("POST /api/users", apiCfg.createUserHandler)
 	mux.HandleFunc("PUT /api/users", apiCfg.updateUserHandler)
+	mux.HandleFunc("DELETE /api/users", apiCfg.deleteUserHandler)
+	mux.HandleFunc("GET /api/me", apiCfg.meHandler)
+	mux.HandleFunc("PUT /api/me/pinned-chirp", apiCfg.pinChirpHandler)
+	mux.HandleFunc("DELETE /api/me/pinned-chirp", apiCfg.unpinChirpHandler)
+	mux.HandleFunc("POST /api/me/deactivate", apiCfg.deactivateHandler)
+	mux.HandleFunc("POST /api/me/reactivate", apiCfg.reactivateHandler)
+	mux.HandleFunc("GET /api/users/{userID}/stats", apiCfg.getUserStatsHandler)
+	mux.HandleFunc("POST /api/users/bulk-get", apiCfg.bulkGetUsersHandler)
+	mux.HandleFunc("POST /api/users/{userID}/block", apiCfg.blockUserHandler)
+	mux.HandleFunc("DELETE /api/users/{userID}/block", apiCfg.unblockUserHandler)
 	mux.HandleFunc("POST /api/login", apiCfg.loginHandler)
 	mux.HandleFunc("POST /api/refresh", apiCfg.refreshHandler)
 	mux.HandleFunc("POST /api/revoke", apiCfg.revokeHandler)
+	mux.HandleFunc("DELETE /api/revoke", apiCfg.revokeHandler)
+	mux.HandleFunc("POST /api/password/reset-request", apiCfg.passwordResetRequestHandler)
+	mux.HandleFunc("POST /api/password/reset", apiCfg.passwordResetHandler)
+	mux.HandleFunc("POST /api/password/change", apiCfg.passwordChangeHandler)
 	mux.HandleFunc("POST /api/chirps", apiCfg.createChirpHandler)
+	mux.HandleFunc("POST /api/chirps/batch", apiCfg.createChirpsBatchHandler)
+	mux.HandleFunc("POST /api/chirps/bulk-get", apiCfg.bulkGetChirpsHandler)
+	mux.HandleFunc("POST /api/chirps/validate", apiCfg.validateChirpHandler)
 	mux.HandleFunc("GET /api/chirps", apiCfg.getChirpsHandler)
+	mux.HandleFunc("GET /api/chirps.csv", apiCfg.exportChirpsCSVHandler)
+	mux.HandleFunc("GET /api/chirps/stream", apiCfg.streamChirpsHandler)
+	mux.HandleFunc("GET /api/chirps/activity", apiCfg.getChirpActivityHandler)
+	mux.HandleFunc("GET /api/chirps/latest", apiCfg.getLatestChirpHandler)
+	mux.HandleFunc("GET /api/ws", apiCfg.websocketHandler)
 	mux.HandleFunc("GET /api/chirps/{chirpID}", apiCfg.getChirpHandler)
+	mux.HandleFunc("HEAD /api/chirps/{chirpID}", apiCfg.headChirpHandler)
+	mux.HandleFunc("GET /api/chirps/{chirpID}/author", apiCfg.getChirpAuthorHandler)
 	mux.HandleFunc("DELETE /api/chirps/{chirpID}", apiCfg.deleteChirpHandler)
+	mux.HandleFunc("POST /api/chirps/{chirpID}/report", apiCfg.reportChirpHandler)
+	mux.HandleFunc("POST /api/drafts", apiCfg.createDraftHandler)
+	mux.HandleFunc("GET /api/drafts", apiCfg.getDraftsHandler)
+	mux.HandleFunc("GET /api/drafts/{draftID}", apiCfg.getDraftHandler)
+	mux.HandleFunc("PUT /api/drafts/{draftID}", apiCfg.updateDraftHandler)
+	mux.HandleFunc("DELETE /api/drafts/{draftID}", apiCfg.deleteDraftHandler)
+	mux.HandleFunc("POST /api/drafts/{draftID}/publish", apiCfg.publishDraftHandler)
+	mux.HandleFunc("GET /api/hashtags/{tag}", apiCfg.getChirpsByHashtagHandler)
+	mux.HandleFunc("GET /api/trends", apiCfg.getTrendsHandler)
+	mux.HandleFunc("GET /api/stats", apiCfg.getPlatformStatsHandler)
 	mux.HandleFunc("POST /api/polka/webhooks", apiCfg.webhookHandler)
 	mux.HandleFunc("GET /api/healthz", healthzHandler)
 	mux.HandleFunc("GET /api/metrics", apiCfg.metricsHandler)
@@ -673,18 +2204,76 @@ func main() {
 	// Admin endpoints
 	mux.HandleFunc("GET /admin/metrics", apiCfg.adminMetricsHandler)
 	mux.HandleFunc("POST /admin/reset", apiCfg.resetHandler)
+	mux.HandleFunc("GET /admin/users", apiCfg.adminUsersHandler)
+	mux.HandleFunc("PUT /admin/users/{userID}/chirpy-red", apiCfg.adminSetChirpyRedHandler)
+	mux.HandleFunc("PUT /admin/users/{userID}/verified", apiCfg.adminSetVerifiedHandler)
+	mux.HandleFunc("DELETE /admin/chirps/{chirpID}", apiCfg.adminDeleteChirpHandler)
+	mux.HandleFunc("GET /admin/reports", apiCfg.adminReportsHandler)
+
+	// Profiling and token debugging expose internals, so they only exist in
+	// dev. They're mounted on our mux explicitly; nothing serves
+	// http.DefaultServeMux.
+	if platform == "dev" {
+		mux.HandleFunc("/debug/pprof/", pprof.Index)
+		mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+		mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+		mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+		mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+		mux.HandleFunc("GET /api/debug/token", apiCfg.debugTokenHandler)
+	}
+
+	// Serve only the static directory, never the working directory with its
+	// source and .env
+	staticDir := os.Getenv("STATIC_DIR")
+	if staticDir == "" {
+		staticDir = "./public"
+	}
+	info, err := os.Stat(staticDir)
+	if err != nil || !info.IsDir() {
+		log.Fatalf("STATIC_DIR %q must be an existing directory", staticDir)
+	}
 
 	// Fileserver remains at the /app/ path
-	fsHandler := http.StripPrefix("/app/", http.FileServer(http.Dir(".")))
-	mux.Handle("/app/", apiCfg.middlewareMetricsInc(fsHandler))
+	fsHandler := http.StripPrefix("/app/", http.FileServer(http.Dir(staticDir)))
+	mux.Handle("/app/", apiCfg.middlewareMetricsInc(middlewareNoDotfiles(fsHandler)))
+
+	requestTimeout, err := durationFromEnv("REQUEST_TIMEOUT", 10*time.Second)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	maxConcurrentRequests, err := intFromEnv("MAX_CONCURRENT_REQUESTS", 1000)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	handler := middlewarePrettyJSON(middlewareTimeout(requestTimeout, mux))
+	handler = middlewareDevBodyLog(platform, handler)
+	// The limiter sits inside CORS so browsers can read its 503s
+	handler = middlewareConcurrencyLimit(maxConcurrentRequests, handler)
+	handler = middlewareRequestID(middlewareRecover(cors.middleware(handler)))
 
 	server := &http.Server{
 		Addr:    ":8080",
-		Handler: mux,
+		Handler: handler,
+	}
+
+	// TLS is optional, for single-node deployments with no proxy in front
+	tlsCertFile := os.Getenv("TLS_CERT_FILE")
+	tlsKeyFile := os.Getenv("TLS_KEY_FILE")
+	if (tlsCertFile == "") != (tlsKeyFile == "") {
+		log.Fatal("TLS_CERT_FILE and TLS_KEY_FILE must be set together")
 	}
 
-	log.Println("Server starting on :8080...")
-	if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+	if tlsCertFile != "" {
+		server.TLSConfig = &tls.Config{MinVersion: tls.VersionTLS12}
+		slog.Info("Server starting", "addr", server.Addr, "tls", true)
+		err = server.ListenAndServeTLS(tlsCertFile, tlsKeyFile)
+	} else {
+		slog.Info("Server starting", "addr", server.Addr)
+		err = server.ListenAndServe()
+	}
+	if err != nil && err != http.ErrServerClosed {
 		log.Fatalf("Server failed to start: %v", err)
 	}
 }
@@ -0,0 +1,2028 @@
+package main
+
+import (
+	"chirpy/internal/auth"
+	"chirpy/internal/database"
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+func TestSanitizeChirp(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  string
+	}{
+		{
+			name:  "clean chirp is unchanged",
+			input: "I had something interesting for breakfast",
+			want:  "I had something interesting for breakfast",
+		},
+		{
+			name:  "lowercase profanity",
+			input: "This is a kerfuffle opinion I need to share with the world",
+			want:  "This is a **** opinion I need to share with the world",
+		},
+		{
+			name:  "mixed case profanity",
+			input: "I hear Mastodon is better than Chirpy. sHaRbErT I need to migrate",
+			want:  "I hear Mastodon is better than Chirpy. **** I need to migrate",
+		},
+		{
+			name:  "full-width profanity",
+			input: "what a ｆｏｒｎａｘ day",
+			want:  "what a **** day",
+		},
+		{
+			name:  "punctuation prevents a match",
+			input: "I really need a kerfuffle! to go to bed",
+			want:  "I really need a kerfuffle! to go to bed",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := sanitizeChirp(tt.input, defaultProfanityReplacement, profanityMatchWord)
+			if got != tt.want {
+				t.Errorf("sanitizeChirp(%q) = %q, want %q", tt.input, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateAvatarURL(t *testing.T) {
+	tests := []struct {
+		input   string
+		wantErr bool
+	}{
+		{input: "https://example.com/avatar.png", wantErr: false},
+		{input: "http://example.com/a.jpg", wantErr: false},
+		{input: "data:image/png;base64,iVBORw0KGgo=", wantErr: true},
+		{input: "javascript:alert(1)", wantErr: true},
+		{input: "not a url", wantErr: true},
+		{input: "https://", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		err := validateAvatarURL(tt.input)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("validateAvatarURL(%q) error = %v, wantErr %v", tt.input, err, tt.wantErr)
+		}
+	}
+}
+
+func TestBioParam(t *testing.T) {
+	long := strings.Repeat("é", maxBioLength)
+
+	got, err := bioParam(&long, defaultProfanityReplacement, profanityMatchWord)
+	if err != nil {
+		t.Fatalf("bioParam at the limit returned error: %v", err)
+	}
+	if got.String != long {
+		t.Errorf("bioParam changed a clean bio")
+	}
+
+	tooLong := long + "a"
+	if _, err := bioParam(&tooLong, defaultProfanityReplacement, profanityMatchWord); err != errBioTooLong {
+		t.Errorf("bioParam over the limit error = %v, want %v", err, errBioTooLong)
+	}
+
+	profane := "I love a good kerfuffle"
+	got, err = bioParam(&profane, defaultProfanityReplacement, profanityMatchWord)
+	if err != nil || got.String != "I love a good ****" {
+		t.Errorf("bioParam(%q) = %q, %v; want masked bio", profane, got.String, err)
+	}
+
+	if got, _ := bioParam(nil, defaultProfanityReplacement, profanityMatchWord); got.Valid {
+		t.Errorf("bioParam(nil) should leave the bio unchanged")
+	}
+}
+
+func TestChirpCursorRoundTrip(t *testing.T) {
+	createdAt := time.Date(2024, 5, 1, 12, 30, 0, 123456000, time.UTC)
+	id := uuid.New()
+
+	got, err := decodeChirpCursor(encodeChirpCursor(createdAt, id))
+	if err != nil {
+		t.Fatalf("decodeChirpCursor returned error: %v", err)
+	}
+	if !got.CreatedAt.Equal(createdAt) || got.ID != id {
+		t.Errorf("decodeChirpCursor = %+v, want {%v %v}", got, createdAt, id)
+	}
+}
+
+func TestDecodeChirpCursorRejectsMalformed(t *testing.T) {
+	tests := []string{
+		"not base64!",
+		base64.RawURLEncoding.EncodeToString([]byte("no separator")),
+		base64.RawURLEncoding.EncodeToString([]byte("yesterday|" + uuid.NewString())),
+		base64.RawURLEncoding.EncodeToString([]byte("2024-05-01T12:30:00Z|not-a-uuid")),
+	}
+
+	for _, input := range tests {
+		if _, err := decodeChirpCursor(input); err != errInvalidCursor {
+			t.Errorf("decodeChirpCursor(%q) error = %v, want %v", input, err, errInvalidCursor)
+		}
+	}
+}
+
+func TestChirpyRedForEvent(t *testing.T) {
+	tests := []struct {
+		event         string
+		wantChirpyRed bool
+		wantHandled   bool
+	}{
+		{event: "user.upgraded", wantChirpyRed: true, wantHandled: true},
+		{event: "user.downgraded", wantChirpyRed: false, wantHandled: true},
+		{event: "user.deleted", wantChirpyRed: false, wantHandled: false},
+		{event: "", wantChirpyRed: false, wantHandled: false},
+	}
+
+	for _, tt := range tests {
+		gotChirpyRed, gotHandled := chirpyRedForEvent(tt.event)
+		if gotChirpyRed != tt.wantChirpyRed || gotHandled != tt.wantHandled {
+			t.Errorf("chirpyRedForEvent(%q) = (%v, %v), want (%v, %v)", tt.event, gotChirpyRed, gotHandled, tt.wantChirpyRed, tt.wantHandled)
+		}
+	}
+}
+
+func TestValidateChirpTrimsWhitespace(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		want    string
+		wantErr error
+	}{
+		{name: "surrounding whitespace is trimmed", input: "  hello world \n", want: "hello world"},
+		{name: "whitespace-only is empty", input: "   \t\n ", wantErr: errChirpEmpty},
+		{name: "empty is rejected", input: "", wantErr: errChirpEmpty},
+		{name: "padding does not count toward the limit", input: "   " + strings.Repeat("a", 140) + "   ", want: strings.Repeat("a", 140)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := validateChirp(tt.input, defaultMaxChirpLength, defaultProfanityReplacement, profanityMatchWord)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("validateChirp(%q) error = %v, want %v", tt.input, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("validateChirp(%q) = %q, want %q", tt.input, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateChirpCountsRunes(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		wantErr error
+	}{
+		{name: "140 emoji are accepted", input: strings.Repeat("🐦", defaultMaxChirpLength)},
+		{name: "140 accented letters are accepted", input: strings.Repeat("é", defaultMaxChirpLength)},
+		{name: "141 runes are rejected", input: strings.Repeat("🐦", defaultMaxChirpLength) + "a", wantErr: errChirpTooLong},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := validateChirp(tt.input, defaultMaxChirpLength, defaultProfanityReplacement, profanityMatchWord)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("validateChirp error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestIsTransientDBError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{name: "nil", err: nil, want: false},
+		{name: "bad connection", err: driver.ErrBadConn, want: true},
+		{name: "connection refused", err: fmt.Errorf("dial: %w", syscall.ECONNREFUSED), want: true},
+		{name: "connection failure", err: &pq.Error{Code: "08006"}, want: true},
+		{name: "admin shutdown", err: &pq.Error{Code: "57P01"}, want: true},
+		{name: "query canceled", err: &pq.Error{Code: "57014"}, want: false},
+		{name: "unique violation", err: &pq.Error{Code: "23505"}, want: false},
+		{name: "no rows", err: sql.ErrNoRows, want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isTransientDBError(tt.err); got != tt.want {
+				t.Errorf("isTransientDBError(%v) = %v, want %v", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRetryRead(t *testing.T) {
+	t.Run("recovers from a transient error", func(t *testing.T) {
+		calls := 0
+		got, err := retryRead(context.Background(), func() (int, error) {
+			calls++
+			if calls == 1 {
+				return 0, driver.ErrBadConn
+			}
+			return 42, nil
+		})
+		if err != nil || got != 42 || calls != 2 {
+			t.Errorf("retryRead = (%d, %v) after %d calls, want (42, nil) after 2", got, err, calls)
+		}
+	})
+
+	t.Run("gives up after the retry limit", func(t *testing.T) {
+		calls := 0
+		_, err := retryRead(context.Background(), func() (int, error) {
+			calls++
+			return 0, driver.ErrBadConn
+		})
+		if err != driver.ErrBadConn || calls != maxReadRetries+1 {
+			t.Errorf("retryRead error = %v after %d calls, want %v after %d", err, calls, driver.ErrBadConn, maxReadRetries+1)
+		}
+	})
+
+	t.Run("does not retry constraint violations", func(t *testing.T) {
+		calls := 0
+		_, err := retryRead(context.Background(), func() (int, error) {
+			calls++
+			return 0, &pq.Error{Code: "23505"}
+		})
+		if err == nil || calls != 1 {
+			t.Errorf("retryRead made %d calls for a non-transient error, want 1", calls)
+		}
+	})
+}
+
+func TestCORSPolicyWithCredentials(t *testing.T) {
+	policy, err := newCORSPolicy("https://app.example.com, https://admin.example.com", true)
+	if err != nil {
+		t.Fatalf("newCORSPolicy returned error: %v", err)
+	}
+	handler := policy.middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	tests := []struct {
+		name            string
+		origin          string
+		wantAllowOrigin string
+		wantCredentials string
+	}{
+		{name: "allowed origin is echoed", origin: "https://app.example.com", wantAllowOrigin: "https://app.example.com", wantCredentials: "true"},
+		{name: "disallowed origin gets no CORS headers", origin: "https://evil.example.com"},
+		{name: "same-origin request gets no CORS headers", origin: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/api/chirps", nil)
+			if tt.origin != "" {
+				req.Header.Set("Origin", tt.origin)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			if got := rec.Header().Get("Access-Control-Allow-Origin"); got != tt.wantAllowOrigin {
+				t.Errorf("Access-Control-Allow-Origin = %q, want %q", got, tt.wantAllowOrigin)
+			}
+			if got := rec.Header().Get("Access-Control-Allow-Credentials"); got != tt.wantCredentials {
+				t.Errorf("Access-Control-Allow-Credentials = %q, want %q", got, tt.wantCredentials)
+			}
+		})
+	}
+}
+
+func TestCORSPolicyPreflight(t *testing.T) {
+	policy, err := newCORSPolicy("https://app.example.com", false)
+	if err != nil {
+		t.Fatalf("newCORSPolicy returned error: %v", err)
+	}
+	called := false
+	handler := policy.middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+	}))
+
+	req := httptest.NewRequest(http.MethodOptions, "/api/chirps", nil)
+	req.Header.Set("Origin", "https://app.example.com")
+	req.Header.Set("Access-Control-Request-Method", http.MethodPost)
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	if called {
+		t.Errorf("preflight request reached the wrapped handler")
+	}
+	if rec.Code != http.StatusNoContent {
+		t.Errorf("preflight status = %d, want %d", rec.Code, http.StatusNoContent)
+	}
+	if rec.Header().Get("Access-Control-Allow-Methods") == "" {
+		t.Errorf("preflight response is missing Access-Control-Allow-Methods")
+	}
+	if got := rec.Header().Get("Access-Control-Allow-Credentials"); got != "" {
+		t.Errorf("Access-Control-Allow-Credentials = %q without credentials enabled", got)
+	}
+}
+
+func TestCORSPolicyCheckWebSocketOrigin(t *testing.T) {
+	policy, err := newCORSPolicy("https://app.example.com", false)
+	if err != nil {
+		t.Fatalf("newCORSPolicy returned error: %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		policy *corsPolicy
+		origin string
+		want   bool
+	}{
+		{name: "allowlisted origin", policy: policy, origin: "https://app.example.com", want: true},
+		{name: "same origin", policy: policy, origin: "http://api.example.com", want: true},
+		{name: "no origin", policy: policy, origin: "", want: true},
+		{name: "other origin", policy: policy, origin: "https://evil.example.com", want: false},
+		{name: "no policy", policy: nil, origin: "https://app.example.com", want: false},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, "http://api.example.com/api/ws", nil)
+		if tt.origin != "" {
+			req.Header.Set("Origin", tt.origin)
+		}
+		if got := tt.policy.checkWebSocketOrigin(req); got != tt.want {
+			t.Errorf("%s: checkWebSocketOrigin = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestNewCORSPolicyRejectsWildcardWithCredentials(t *testing.T) {
+	if _, err := newCORSPolicy("*", true); err == nil {
+		t.Errorf("newCORSPolicy accepted * with credentials")
+	}
+}
+
+func TestMiddlewareTimeout(t *testing.T) {
+	handler := middlewareTimeout(10*time.Millisecond, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Stand in for a query that is cancelled when the deadline passes
+		<-r.Context().Done()
+		respondWithErrorCode(w, http.StatusInternalServerError, codeInternalError, "Failed to retrieve chirps")
+	}))
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/chirps", nil))
+
+	if rec.Code != http.StatusGatewayTimeout {
+		t.Errorf("status = %d, want %d", rec.Code, http.StatusGatewayTimeout)
+	}
+	if !strings.Contains(rec.Body.String(), codeTimeout) {
+		t.Errorf("body = %q, want it to contain %q", rec.Body.String(), codeTimeout)
+	}
+}
+
+func TestEscapeChirpBodies(t *testing.T) {
+	chirps := []Chirp{
+		{Body: `<script>alert("hi")</script>`},
+		{Body: "plain & simple", QuotedChirp: &QuotedChirp{Body: "<b>quoted</b>"}},
+	}
+	escapeChirpBodies(chirps)
+
+	want := []string{"&lt;script&gt;alert(&#34;hi&#34;)&lt;/script&gt;", "plain &amp; simple"}
+	for i, chirp := range chirps {
+		if chirp.Body != want[i] {
+			t.Errorf("chirps[%d].Body = %q, want %q", i, chirp.Body, want[i])
+		}
+	}
+	if got := chirps[1].QuotedChirp.Body; got != "&lt;b&gt;quoted&lt;/b&gt;" {
+		t.Errorf("quoted body = %q, want it escaped too", got)
+	}
+}
+
+func TestNewIDIsTimeOrdered(t *testing.T) {
+	prev := newID()
+	if prev.Version() != 7 {
+		t.Fatalf("newID version = %d, want 7", prev.Version())
+	}
+
+	for i := 0; i < 100; i++ {
+		id := newID()
+		if id.String() <= prev.String() {
+			t.Fatalf("newID %s not after previous %s", id, prev)
+		}
+		prev = id
+	}
+}
+
+func TestZeroFillActivity(t *testing.T) {
+	from := time.Date(2024, 3, 1, 15, 0, 0, 0, time.UTC)
+	to := time.Date(2024, 3, 4, 9, 0, 0, 0, time.UTC)
+	rows := []database.GetChirpActivityRow{
+		{Day: time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC), ChirpCount: 2},
+		{Day: time.Date(2024, 3, 3, 0, 0, 0, 0, time.UTC), ChirpCount: 5},
+	}
+
+	got := zeroFillActivity(from, to, rows)
+	want := []DayActivity{
+		{Date: "2024-03-01", Count: 2},
+		{Date: "2024-03-02", Count: 0},
+		{Date: "2024-03-03", Count: 5},
+		{Date: "2024-03-04", Count: 0},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("zeroFillActivity returned %d days, want %d: %v", len(got), len(want), got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("day %d = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+}
+
+func TestMiddlewareNoDotfiles(t *testing.T) {
+	dir := t.TempDir()
+	for name, content := range map[string]string{
+		"index.html":  "<h1>Chirpy</h1>",
+		".env":        "JWT_SECRET=hunter2",
+		".git/config": "[core]",
+	} {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	handler := middlewareNoDotfiles(http.StripPrefix("/app/", http.FileServer(http.Dir(dir))))
+
+	tests := []struct {
+		path string
+		want int
+	}{
+		{path: "/app/", want: http.StatusOK},
+		{path: "/app/.env", want: http.StatusNotFound},
+		{path: "/app/.git/config", want: http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.path, nil))
+			if rec.Code != tt.want {
+				t.Errorf("GET %s status = %d, want %d", tt.path, rec.Code, tt.want)
+			}
+		})
+	}
+}
+
+// errDatabaseDown is what failingDriver returns for every operation.
+var errDatabaseDown = errors.New("database is down")
+
+// failingDriver is a database/sql driver whose connections fail every
+// operation, for checking that handlers don't mistake outages for bad input.
+type failingDriver struct{}
+
+func (failingDriver) Open(string) (driver.Conn, error) { return failingConn{}, nil }
+
+type failingConn struct{}
+
+func (failingConn) Prepare(string) (driver.Stmt, error) { return nil, errDatabaseDown }
+func (failingConn) Close() error                        { return nil }
+func (failingConn) Begin() (driver.Tx, error)           { return nil, errDatabaseDown }
+
+func init() {
+	sql.Register("failing", failingDriver{})
+}
+
+func TestRefreshHandlerDatabaseErrorIs500(t *testing.T) {
+	db, err := sql.Open("failing", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	cfg := &apiConfig{DB: database.New(db), Conn: db}
+
+	req := httptest.NewRequest(http.MethodPost, "/api/refresh", nil)
+	req.Header.Set("Authorization", "Bearer some-refresh-token")
+	rec := httptest.NewRecorder()
+	cfg.refreshHandler(rec, req)
+
+	if rec.Code != http.StatusInternalServerError {
+		t.Errorf("status = %d, want %d", rec.Code, http.StatusInternalServerError)
+	}
+}
+
+func TestMiddlewareConcurrencyLimit(t *testing.T) {
+	release := make(chan struct{})
+	started := make(chan struct{})
+	handler := middlewareConcurrencyLimit(1, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/block":
+			close(started)
+			<-release
+		case "/panic":
+			panic("handler failed")
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/block", nil))
+	}()
+	<-started
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("status while saturated = %d, want %d", rec.Code, http.StatusServiceUnavailable)
+	}
+	if rec.Header().Get("Retry-After") == "" {
+		t.Errorf("saturated response has no Retry-After header")
+	}
+
+	close(release)
+	<-done
+
+	// A panicking handler must still give its slot back
+	func() {
+		defer func() { recover() }()
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/panic", nil))
+	}()
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("status after slots were released = %d, want %d", rec.Code, http.StatusOK)
+	}
+}
+
+func TestMiddlewareRecover(t *testing.T) {
+	handler := middlewareRecover(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var m map[string]int
+		m["boom"]++
+	}))
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/chirps", nil))
+
+	if rec.Code != http.StatusInternalServerError {
+		t.Errorf("status = %d, want %d", rec.Code, http.StatusInternalServerError)
+	}
+	if got := rec.Header().Get("Content-Type"); got != "application/json" {
+		t.Errorf("Content-Type = %q, want application/json", got)
+	}
+	if !strings.Contains(rec.Body.String(), codeInternalError) {
+		t.Errorf("body = %q, want it to contain %q", rec.Body.String(), codeInternalError)
+	}
+}
+
+func TestValidateChirpConfiguredLimit(t *testing.T) {
+	if _, err := validateChirp(strings.Repeat("a", 280), 280, defaultProfanityReplacement, profanityMatchWord); err != nil {
+		t.Fatalf("validateChirp at the limit returned error: %v", err)
+	}
+
+	_, err := validateChirp(strings.Repeat("a", 281), 280, defaultProfanityReplacement, profanityMatchWord)
+	if !errors.Is(err, errChirpTooLong) {
+		t.Fatalf("validateChirp over the limit error = %v, want %v", err, errChirpTooLong)
+	}
+	if !strings.Contains(err.Error(), "280") {
+		t.Errorf("error message %q doesn't mention the limit", err.Error())
+	}
+	if got := chirpErrorCode(err); got != codeChirpTooLong {
+		t.Errorf("chirpErrorCode = %q, want %q", got, codeChirpTooLong)
+	}
+}
+
+func TestPlatformStatsCache(t *testing.T) {
+	var cache platformStatsCache
+	calls := 0
+	load := func(context.Context) (PlatformStats, error) {
+		calls++
+		if calls == 2 {
+			return PlatformStats{}, errDatabaseDown
+		}
+		return PlatformStats{TotalUsers: int64(calls)}, nil
+	}
+
+	for i := 0; i < 3; i++ {
+		stats, err := cache.get(context.Background(), load)
+		if err != nil || stats.TotalUsers != 1 {
+			t.Fatalf("get = %+v, %v; want the first load's stats", stats, err)
+		}
+	}
+	if calls != 1 {
+		t.Errorf("load called %d times within the TTL, want 1", calls)
+	}
+
+	// A failed refresh after expiry falls back to the stale stats
+	cache.expiresAt = time.Now().Add(-time.Second)
+	stats, err := cache.get(context.Background(), load)
+	if err != nil || stats.TotalUsers != 1 {
+		t.Errorf("get after failed refresh = %+v, %v; want stale stats", stats, err)
+	}
+
+	stats, err = cache.get(context.Background(), load)
+	if err != nil || stats.TotalUsers != 3 {
+		t.Errorf("get after successful refresh = %+v, %v; want fresh stats", stats, err)
+	}
+}
+
+func TestMiddlewarePrettyJSON(t *testing.T) {
+	handler := middlewarePrettyJSON(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		respondWithJSON(w, http.StatusOK, map[string]int{"total_users": 3})
+	}))
+
+	tests := []struct {
+		path string
+		want string
+	}{
+		{path: "/api/stats", want: `{"total_users":3}`},
+		{path: "/api/stats?pretty=true", want: "{\n  \"total_users\": 3\n}\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.path, nil))
+			if got := rec.Body.String(); got != tt.want {
+				t.Errorf("body = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestChirpETag(t *testing.T) {
+	chirp := Chirp{ID: uuid.New(), UpdatedAt: time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC), Body: "hello"}
+	etag := chirpETag(chirp, false)
+
+	if chirpETag(chirp, false) != etag {
+		t.Errorf("chirpETag is not stable for an unchanged chirp")
+	}
+	if chirpETag(chirp, true) == etag {
+		t.Errorf("escaped and raw bodies share an ETag")
+	}
+
+	edited := chirp
+	edited.UpdatedAt = edited.UpdatedAt.Add(time.Second)
+	if chirpETag(edited, false) == etag {
+		t.Errorf("ETag did not change when the chirp was updated")
+	}
+
+	withAuthor := chirp
+	withAuthor.Author = &PublicUser{ID: uuid.New(), Bio: "old"}
+	sameAuthor := withAuthor
+	sameAuthor.Author = &PublicUser{ID: withAuthor.Author.ID, Bio: "old"}
+	if chirpETag(withAuthor, false) != chirpETag(sameAuthor, false) {
+		t.Errorf("ETag changed for an unchanged embedded author")
+	}
+	editedAuthor := withAuthor
+	editedAuthor.Author = &PublicUser{ID: withAuthor.Author.ID, Bio: "new"}
+	if chirpETag(withAuthor, false) == chirpETag(editedAuthor, false) {
+		t.Errorf("ETag did not change when the embedded author's profile changed")
+	}
+
+	quote := chirp
+	quote.QuotedChirp = &QuotedChirp{ID: uuid.New(), Body: "quoted"}
+	unavailable := chirp
+	unavailable.QuotedChirpUnavailable = true
+	if chirpETag(quote, false) == chirpETag(unavailable, false) {
+		t.Errorf("ETag did not change when the quoted chirp became unavailable")
+	}
+
+	tests := []struct {
+		header string
+		want   bool
+	}{
+		{header: "", want: false},
+		{header: etag, want: true},
+		{header: "W/" + etag, want: true},
+		{header: `"other", ` + etag, want: true},
+		{header: "*", want: true},
+		{header: `"other"`, want: false},
+	}
+	for _, tt := range tests {
+		if got := etagMatches(tt.header, etag); got != tt.want {
+			t.Errorf("etagMatches(%q) = %v, want %v", tt.header, got, tt.want)
+		}
+	}
+}
+
+func TestDecodeCreateChirpBody(t *testing.T) {
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+		want        string
+		wantStatus  int
+	}{
+		{name: "json", contentType: "application/json", body: `{"body":"hello world"}`, want: "hello world"},
+		{name: "form", contentType: "application/x-www-form-urlencoded", body: "body=hello+world%21", want: "hello world!"},
+		{name: "form with charset", contentType: "application/x-www-form-urlencoded; charset=utf-8", body: "body=hi", want: "hi"},
+		{name: "malformed json", contentType: "application/json", body: `{"body":`, wantStatus: http.StatusBadRequest},
+		{name: "plain text", contentType: "text/plain", body: "hello", wantStatus: http.StatusUnsupportedMediaType},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/api/chirps", strings.NewReader(tt.body))
+			req.Header.Set("Content-Type", tt.contentType)
+			rec := httptest.NewRecorder()
+
+			got, ok := decodeCreateChirpBody(rec, req)
+			if tt.wantStatus != 0 {
+				if ok || rec.Code != tt.wantStatus {
+					t.Errorf("ok = %v, status = %d; want failure with %d", ok, rec.Code, tt.wantStatus)
+				}
+				return
+			}
+			if !ok {
+				t.Fatalf("decodeCreateChirpBody failed with status %d", rec.Code)
+			}
+			if got.Body != tt.want {
+				t.Errorf("Body = %q, want %q", got.Body, tt.want)
+			}
+		})
+	}
+}
+
+func TestAdminAuthorization(t *testing.T) {
+	db, err := sql.Open("failing", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	tests := []struct {
+		name     string
+		platform string
+		adminKey string
+		header   string
+		reset    bool
+		// A request that gets past authorization hits the failing database
+		want int
+	}{
+		{name: "users with key", platform: "prod", adminKey: "secret", header: "ApiKey secret", want: http.StatusInternalServerError},
+		{name: "users with wrong key", platform: "prod", adminKey: "secret", header: "ApiKey nope", want: http.StatusUnauthorized},
+		{name: "users without key", platform: "dev", adminKey: "secret", want: http.StatusUnauthorized},
+		{name: "users when admin key unset", platform: "prod", header: "ApiKey ", want: http.StatusUnauthorized},
+		{name: "reset in dev without key", platform: "dev", reset: true, want: http.StatusInternalServerError},
+		{name: "reset outside dev with key", platform: "prod", adminKey: "secret", header: "ApiKey secret", reset: true, want: http.StatusForbidden},
+		{name: "reset outside dev without key", platform: "prod", adminKey: "secret", reset: true, want: http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &apiConfig{DB: database.New(db), Conn: db, Platform: tt.platform, AdminKey: tt.adminKey}
+			req := httptest.NewRequest(http.MethodGet, "/admin/users", nil)
+			if tt.header != "" {
+				req.Header.Set("Authorization", tt.header)
+			}
+			rec := httptest.NewRecorder()
+			if tt.reset {
+				cfg.resetHandler(rec, req)
+			} else {
+				cfg.adminUsersHandler(rec, req)
+			}
+
+			if rec.Code != tt.want {
+				t.Errorf("status = %d, want %d", rec.Code, tt.want)
+			}
+		})
+	}
+}
+
+func TestVisibleChirps(t *testing.T) {
+	alice, bob := uuid.New(), uuid.New()
+	chirps := func() []Chirp {
+		return []Chirp{
+			{Body: "a1", UserID: alice, Visibility: database.ChirpVisibilityPublic},
+			{Body: "b1", UserID: bob, Visibility: database.ChirpVisibilityPublic},
+			{Body: "a2", UserID: alice, Visibility: database.ChirpVisibilityPrivate},
+		}
+	}
+	bodies := func(chirps []Chirp) []string {
+		got := []string{}
+		for _, chirp := range chirps {
+			got = append(got, chirp.Body)
+		}
+		return got
+	}
+
+	tests := []struct {
+		name   string
+		viewer chirpViewer
+		want   []string
+	}{
+		{name: "anonymous", viewer: chirpViewer{}, want: []string{"a1", "b1"}},
+		{name: "author", viewer: authorViewer(alice), want: []string{"a1", "b1", "a2"}},
+		{name: "other user", viewer: authorViewer(bob), want: []string{"a1", "b1"}},
+		{
+			name:   "blocked author",
+			viewer: chirpViewer{id: uuid.NullUUID{UUID: bob, Valid: true}, hidden: map[uuid.UUID]bool{alice: true}},
+			want:   []string{"b1"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := bodies(visibleChirps(chirps(), tt.viewer)); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("visibleChirps = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestProfanityFlagMode(t *testing.T) {
+	if !containsProfanity("what a Kerfuffle today", profanityMatchWord) {
+		t.Errorf("containsProfanity missed a profane word")
+	}
+	if containsProfanity("what a day", profanityMatchWord) {
+		t.Errorf("containsProfanity flagged a clean chirp")
+	}
+
+	body, err := validateChirp("what a kerfuffle", defaultMaxChirpLength, "", profanityMatchWord)
+	if err != nil || body != "what a kerfuffle" {
+		t.Errorf("validateChirp without censoring = %q, %v; want the body unchanged", body, err)
+	}
+
+	cfg := &apiConfig{ProfanityMode: profanityFlag}
+	chirps := []Chirp{{Body: "what a kerfuffle"}, {Body: "what a day"}}
+	cfg.flagProfanity(chirps)
+	if !chirps[0].ContainsProfanity || chirps[1].ContainsProfanity {
+		t.Errorf("flagProfanity = %+v, want only the first chirp flagged", chirps)
+	}
+
+	if got := sanitizeChirp("what a kerfuffle", "[redacted]", profanityMatchWord); got != "what a [redacted]" {
+		t.Errorf("sanitizeChirp with a custom replacement = %q", got)
+	}
+	censorCfg := &apiConfig{ProfanityMode: profanityCensor, ProfanityReplacement: "#"}
+	if got := censorCfg.chirpCensorReplacement(); got != "#" {
+		t.Errorf("chirpCensorReplacement in censor mode = %q, want %q", got, "#")
+	}
+	if got := cfg.chirpCensorReplacement(); got != "" {
+		t.Errorf("chirpCensorReplacement in flag mode = %q, want none", got)
+	}
+
+	if _, err := parseProfanityMode("shout"); err == nil {
+		t.Errorf("parseProfanityMode accepted an unknown mode")
+	}
+}
+
+func TestUserCache(t *testing.T) {
+	cache := newUserCache(2, time.Minute)
+	a := database.User{ID: uuid.New()}
+	b := database.User{ID: uuid.New()}
+	c := database.User{ID: uuid.New()}
+
+	for _, user := range []database.User{a, b} {
+		_, gen, _ := cache.get(user.ID)
+		cache.add(user, gen)
+	}
+
+	// Touching a makes b the least recently used, so adding c evicts b
+	if _, _, ok := cache.get(a.ID); !ok {
+		t.Fatal("a missing right after add")
+	}
+	_, gen, _ := cache.get(c.ID)
+	cache.add(c, gen)
+	if _, _, ok := cache.get(b.ID); ok {
+		t.Error("b survived eviction")
+	}
+
+	// A load that raced with an invalidation isn't stored
+	_, gen, _ = cache.get(b.ID)
+	cache.Invalidate(a.ID)
+	cache.add(b, gen)
+	if _, _, ok := cache.get(a.ID); ok {
+		t.Error("a still cached after Invalidate")
+	}
+	if _, _, ok := cache.get(b.ID); ok {
+		t.Error("stale load was cached after an invalidation")
+	}
+
+	// Expired entries are treated as misses
+	cache.entries[c.ID].Value.(*userCacheEntry).expiresAt = time.Now().Add(-time.Second)
+	if _, _, ok := cache.get(c.ID); ok {
+		t.Error("expired entry was served")
+	}
+
+	hits, misses := cache.Stats()
+	if hits != 1 || misses != 8 {
+		t.Errorf("Stats = %d hits, %d misses; want 1, 8", hits, misses)
+	}
+}
+
+func TestDecodeDraftBody(t *testing.T) {
+	tests := []struct {
+		name     string
+		body     string
+		wantOK   bool
+		wantCode int
+	}{
+		{"past the chirp limit", strings.Repeat("a", defaultMaxChirpLength+1), true, 0},
+		{"past the draft limit", strings.Repeat("a", maxDraftLength+1), false, http.StatusUnprocessableEntity},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			payload := `{"body":"` + tt.body + `"}`
+			req := httptest.NewRequest(http.MethodPost, "/api/drafts", strings.NewReader(payload))
+			req.Header.Set("Content-Type", "application/json")
+			rec := httptest.NewRecorder()
+
+			reqBody, ok := decodeDraftBody(rec, req)
+			if ok != tt.wantOK {
+				t.Fatalf("ok = %v, want %v", ok, tt.wantOK)
+			}
+			if ok && reqBody.Body != tt.body {
+				t.Errorf("body was altered while decoding")
+			}
+			if !ok && rec.Code != tt.wantCode {
+				t.Errorf("status = %d, want %d", rec.Code, tt.wantCode)
+			}
+		})
+	}
+}
+
+func TestMiddlewareDevBodyLog(t *testing.T) {
+	var got string
+	echo := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		dat, _ := io.ReadAll(r.Body)
+		got = string(dat)
+		respondWithJSON(w, http.StatusCreated, map[string]string{"ok": "yes"})
+	})
+
+	for _, platform := range []string{"dev", "prod"} {
+		got = ""
+		req := httptest.NewRequest(http.MethodPost, "/api/chirps", strings.NewReader(`{"body":"hi"}`))
+		rec := httptest.NewRecorder()
+		middlewareDevBodyLog(platform, echo).ServeHTTP(rec, req)
+
+		if got != `{"body":"hi"}` {
+			t.Errorf("%s: handler read %q, want the full request body", platform, got)
+		}
+		if rec.Code != http.StatusCreated || !strings.Contains(rec.Body.String(), `"ok"`) {
+			t.Errorf("%s: response = %d %q, want it passed through", platform, rec.Code, rec.Body.String())
+		}
+	}
+
+	// A large body streams through to the handler instead of being read
+	// into memory before it runs
+	upload := &countingReader{r: strings.NewReader(strings.Repeat("x", 4*maxLoggedBodyBytes))}
+	req := httptest.NewRequest(http.MethodPost, "/api/chirps", upload)
+	middlewareDevBodyLog("dev", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if upload.n != 0 {
+			t.Errorf("%d body bytes were read before the handler ran", upload.n)
+		}
+		dat, _ := io.ReadAll(r.Body)
+		if len(dat) != 4*maxLoggedBodyBytes {
+			t.Errorf("handler read %d bytes, want %d", len(dat), 4*maxLoggedBodyBytes)
+		}
+	})).ServeHTTP(httptest.NewRecorder(), req)
+
+	// Outside dev the handler must see the server's own writer
+	rec := httptest.NewRecorder()
+	middlewareDevBodyLog("prod", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if w != http.ResponseWriter(rec) {
+			t.Error("prod requests went through the body logger")
+		}
+	})).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/healthz", nil))
+}
+
+// countingReader counts the bytes read through it.
+type countingReader struct {
+	r io.Reader
+	n int
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += n
+	return n, err
+}
+
+func TestDebugTokenHandler(t *testing.T) {
+	keys, err := auth.NewKeyRing("k1", map[string]string{"k1": "test-secret"})
+	if err != nil {
+		t.Fatalf("NewKeyRing failed: %v", err)
+	}
+	userID := uuid.New()
+	expired, err := keys.MakeJWT(userID, -time.Minute)
+	if err != nil {
+		t.Fatalf("MakeJWT failed: %v", err)
+	}
+
+	for _, platform := range []string{"dev", "prod"} {
+		cfg := &apiConfig{Platform: platform, JWTKeys: keys}
+		req := httptest.NewRequest(http.MethodGet, "/api/debug/token", nil)
+		req.Header.Set("Authorization", "Bearer "+expired)
+		rec := httptest.NewRecorder()
+		cfg.debugTokenHandler(rec, req)
+
+		if platform != "dev" {
+			if rec.Code != http.StatusNotFound {
+				t.Errorf("%s: status = %d, want %d", platform, rec.Code, http.StatusNotFound)
+			}
+			continue
+		}
+
+		var claims debugTokenClaims
+		if err := json.NewDecoder(rec.Body).Decode(&claims); err != nil {
+			t.Fatalf("decoding response: %v", err)
+		}
+		if rec.Code != http.StatusOK || claims.Subject != userID.String() || !claims.Expired || claims.Valid {
+			t.Errorf("%s: %d %+v, want the expired token's claims", platform, rec.Code, claims)
+		}
+	}
+}
+
+func TestGetLatestChirpHandlerErrors(t *testing.T) {
+	db, err := sql.Open("failing", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	cfg := &apiConfig{DB: database.New(db), Conn: db}
+
+	tests := []struct {
+		target string
+		want   int
+	}{
+		{target: "/api/chirps/latest?escape=xml", want: http.StatusBadRequest},
+		{target: "/api/chirps/latest", want: http.StatusInternalServerError},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		cfg.getLatestChirpHandler(rec, httptest.NewRequest(http.MethodGet, tt.target, nil))
+		if rec.Code != tt.want {
+			t.Errorf("GET %s status = %d, want %d", tt.target, rec.Code, tt.want)
+		}
+	}
+}
+
+func TestCreateChirpValidationStatus(t *testing.T) {
+	keys, err := auth.NewKeyRing("k1", map[string]string{"k1": "test-secret"})
+	if err != nil {
+		t.Fatalf("NewKeyRing failed: %v", err)
+	}
+	token, err := keys.MakeJWT(uuid.New(), time.Hour)
+	if err != nil {
+		t.Fatalf("MakeJWT failed: %v", err)
+	}
+	cfg := &apiConfig{JWTKeys: keys, MaxChirpLength: defaultMaxChirpLength}
+
+	tests := []struct {
+		name string
+		body string
+		want int
+	}{
+		{name: "malformed json", body: `{"body":`, want: http.StatusBadRequest},
+		{name: "too long", body: `{"body":"` + strings.Repeat("a", defaultMaxChirpLength+1) + `"}`, want: http.StatusUnprocessableEntity},
+		{name: "empty", body: `{"body":"  "}`, want: http.StatusUnprocessableEntity},
+		{name: "unknown visibility", body: `{"body":"hi","visibility":"friends"}`, want: http.StatusUnprocessableEntity},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/api/chirps", strings.NewReader(tt.body))
+			req.Header.Set("Content-Type", "application/json")
+			req.Header.Set("Authorization", "Bearer "+token)
+			rec := httptest.NewRecorder()
+			cfg.createChirpHandler(rec, req)
+
+			if rec.Code != tt.want {
+				t.Errorf("status = %d, want %d", rec.Code, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseAuthorIDs(t *testing.T) {
+	a, b, c := uuid.New(), uuid.New(), uuid.New()
+
+	tests := []struct {
+		name    string
+		query   string
+		want    []uuid.UUID
+		wantErr bool
+	}{
+		{name: "none", query: "", want: []uuid.UUID{}},
+		{name: "single", query: "author_id=" + a.String(), want: []uuid.UUID{a}},
+		{name: "repeated", query: "author_id=" + a.String() + "&author_id=" + b.String(), want: []uuid.UUID{a, b}},
+		{name: "comma separated", query: "author_id=" + a.String() + ",%20" + b.String() + "&author_id=" + c.String(), want: []uuid.UUID{a, b, c}},
+		{name: "duplicates", query: "author_id=" + a.String() + "," + a.String(), want: []uuid.UUID{a}},
+		{name: "invalid", query: "author_id=" + a.String() + ",nope", wantErr: true},
+		{name: "trailing comma", query: "author_id=" + a.String() + ",", wantErr: true},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/api/chirps?"+tt.query, nil)
+		got, err := parseAuthorIDs(r)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: err = %v, wantErr %v", tt.name, err, tt.wantErr)
+			continue
+		}
+		if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+
+	ids := make([]string, maxAuthorFilterSize+1)
+	for i := range ids {
+		ids[i] = uuid.New().String()
+	}
+	r := httptest.NewRequest(http.MethodGet, "/api/chirps?author_id="+strings.Join(ids, ","), nil)
+	if _, err := parseAuthorIDs(r); !errors.Is(err, errTooManyAuthors) {
+		t.Errorf("%d authors: err = %v, want errTooManyAuthors", len(ids), err)
+	}
+}
+
+func TestVerifiedIndependentOfChirpyRed(t *testing.T) {
+	for _, red := range []bool{false, true} {
+		for _, verified := range []bool{false, true} {
+			user := databaseUserToUser(database.User{IsChirpyRed: red, IsVerified: verified})
+			if user.IsChirpyRed != red || user.IsVerified != verified {
+				t.Errorf("red=%v verified=%v: got is_chirpy_red=%v is_verified=%v", red, verified, user.IsChirpyRed, user.IsVerified)
+			}
+		}
+	}
+
+	db, err := sql.Open("failing", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	cfg := &apiConfig{DB: database.New(db), Conn: db, AdminKey: "secret"}
+
+	// Each admin endpoint only accepts its own flag, so setting one can't
+	// change the other. A body that gets past validation hits the failing database.
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		body    string
+		want    int
+	}{
+		{name: "verified", handler: cfg.adminSetVerifiedHandler, body: `{"is_verified": true}`, want: http.StatusInternalServerError},
+		{name: "verified with chirpy red flag", handler: cfg.adminSetVerifiedHandler, body: `{"is_chirpy_red": true}`, want: http.StatusUnprocessableEntity},
+		{name: "chirpy red", handler: cfg.adminSetChirpyRedHandler, body: `{"is_chirpy_red": true}`, want: http.StatusInternalServerError},
+		{name: "chirpy red with verified flag", handler: cfg.adminSetChirpyRedHandler, body: `{"is_verified": true}`, want: http.StatusUnprocessableEntity},
+		{name: "verified not a boolean", handler: cfg.adminSetVerifiedHandler, body: `{"is_verified": "yes"}`, want: http.StatusBadRequest},
+		{name: "chirpy red not a boolean", handler: cfg.adminSetChirpyRedHandler, body: `{"is_chirpy_red": "yes"}`, want: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodPut, "/admin/users/x", strings.NewReader(tt.body))
+		req.SetPathValue("userID", uuid.NewString())
+		req.Header.Set("Authorization", "ApiKey secret")
+		req.Header.Set("Content-Type", "application/json")
+		rec := httptest.NewRecorder()
+		tt.handler(rec, req)
+		if rec.Code != tt.want {
+			t.Errorf("%s: status = %d, want %d", tt.name, rec.Code, tt.want)
+		}
+	}
+}
+
+func TestValidateChirpHandler(t *testing.T) {
+	cfg := &apiConfig{
+		MaxChirpLength:       defaultMaxChirpLength,
+		ProfanityMode:        profanityCensor,
+		ProfanityReplacement: defaultProfanityReplacement,
+	}
+
+	tests := []struct {
+		name string
+		body string
+		want chirpValidation
+	}{
+		{
+			name: "valid",
+			body: `{"body":"  hello kerfuffle  "}`,
+			want: chirpValidation{Valid: true, RuneCount: 15, CleanedBody: "hello ****"},
+		},
+		{
+			name: "too long",
+			body: `{"body":"` + strings.Repeat("é", defaultMaxChirpLength+1) + `"}`,
+			want: chirpValidation{RuneCount: defaultMaxChirpLength + 1, CleanedBody: strings.Repeat("é", defaultMaxChirpLength+1), Error: "Chirp is too long, the limit is 140 characters"},
+		},
+		{
+			name: "empty",
+			body: `{"body":"   "}`,
+			want: chirpValidation{Error: errChirpEmpty.Error()},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/api/chirps/validate", strings.NewReader(tt.body))
+			req.Header.Set("Content-Type", "application/json")
+			rec := httptest.NewRecorder()
+			cfg.validateChirpHandler(rec, req)
+
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status = %d, want %d", rec.Code, http.StatusOK)
+			}
+			var got chirpValidation
+			if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+				t.Fatalf("decode response: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestChirpFields(t *testing.T) {
+	for _, query := range []string{"fields=id,nope", "fields=id,,body", "fields=Body"} {
+		r := httptest.NewRequest(http.MethodGet, "/api/chirps?"+query, nil)
+		if _, err := parseChirpFields(r); err == nil {
+			t.Errorf("parseChirpFields(%q) accepted an invalid field", query)
+		}
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "/api/chirps", nil)
+	fields, err := parseChirpFields(r)
+	if err != nil || fields != nil {
+		t.Fatalf("no fields parameter: got %v, %v; want nil, nil", fields, err)
+	}
+	chirp := Chirp{ID: uuid.New(), Body: "hello", UserID: uuid.New()}
+	if got, _ := fields.selectOne(chirp); got != any(chirp) {
+		t.Errorf("no fields parameter changed the chirp: %v", got)
+	}
+
+	r = httptest.NewRequest(http.MethodGet, "/api/chirps?fields=body,id,body,pinned", nil)
+	fields, err = parseChirpFields(r)
+	if err != nil {
+		t.Fatalf("parseChirpFields failed: %v", err)
+	}
+	selected, err := fields.selectAll([]Chirp{chirp})
+	if err != nil {
+		t.Fatalf("selectAll failed: %v", err)
+	}
+	dat, err := json.Marshal(selected)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Unset omitempty fields stay absent even when requested
+	want := `[{"body":"hello","id":"` + chirp.ID.String() + `"}]`
+	if string(dat) != want {
+		t.Errorf("selected chirps = %s, want %s", dat, want)
+	}
+
+	full := chirpETag(chirp, false)
+	if fields.etag(full) == full {
+		t.Errorf("a field selection shares the full chirp's ETag")
+	}
+}
+
+func TestLoginLockout(t *testing.T) {
+	db, err := sql.Open("failing", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	tests := []struct {
+		name      string
+		threshold int
+		// With lockout on, the lock check is the first database read
+		want int
+	}{
+		{name: "lockout disabled", threshold: 0, want: http.StatusUnauthorized},
+		{name: "lockout enabled", threshold: 5, want: http.StatusInternalServerError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &apiConfig{DB: database.New(db), Conn: db, LockoutThreshold: tt.threshold, LockoutCooldown: time.Minute}
+			req := httptest.NewRequest(http.MethodPost, "/api/login", strings.NewReader(`{"email":"a@example.com","password":"pw"}`))
+			req.Header.Set("Content-Type", "application/json")
+			rec := httptest.NewRecorder()
+			cfg.loginHandler(rec, req)
+
+			if rec.Code != tt.want {
+				t.Errorf("status = %d, want %d", rec.Code, tt.want)
+			}
+		})
+	}
+
+	rec := httptest.NewRecorder()
+	respondWithLoginLocked(rec, 90*time.Second+time.Millisecond)
+	if rec.Code != http.StatusLocked {
+		t.Errorf("status = %d, want %d", rec.Code, http.StatusLocked)
+	}
+	if got := rec.Header().Get("Retry-After"); got != "91" {
+		t.Errorf("Retry-After = %q, want %q", got, "91")
+	}
+	if !strings.Contains(rec.Body.String(), codeLoginLocked) {
+		t.Errorf("body %s is missing code %q", rec.Body.String(), codeLoginLocked)
+	}
+}
+
+func TestGetChirpsCountOnly(t *testing.T) {
+	db, err := sql.Open("failing", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	cfg := &apiConfig{DB: database.New(db), Conn: db}
+
+	tests := []struct {
+		target  string
+		want    int
+		wantMsg string
+	}{
+		{target: "/api/chirps?count_only=yes", want: http.StatusBadRequest, wantMsg: "count_only"},
+		{target: "/api/chirps?count_only=true&author_id=nope", want: http.StatusBadRequest, wantMsg: "Invalid author ID"},
+		// The count query is the only database read, and skips the row fetch
+		{target: "/api/chirps?count_only=true", want: http.StatusInternalServerError, wantMsg: "Failed to count chirps"},
+		{target: "/api/chirps?count_only=false", want: http.StatusInternalServerError, wantMsg: "Failed to retrieve chirps"},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		cfg.getChirpsHandler(rec, httptest.NewRequest(http.MethodGet, tt.target, nil))
+		if rec.Code != tt.want {
+			t.Errorf("GET %s status = %d, want %d", tt.target, rec.Code, tt.want)
+		}
+		if !strings.Contains(rec.Body.String(), tt.wantMsg) {
+			t.Errorf("GET %s body = %s, want it to mention %q", tt.target, rec.Body.String(), tt.wantMsg)
+		}
+	}
+}
+
+func TestProfanitySubstringMatch(t *testing.T) {
+	tests := []struct {
+		input     string
+		wordMode  string
+		substring string
+	}{
+		{input: "a superkerfuffle day", wordMode: "a superkerfuffle day", substring: "a super**** day"},
+		{input: "Kerfuffle!", wordMode: "Kerfuffle!", substring: "****!"},
+		{input: "fornaxsharbert", wordMode: "fornaxsharbert", substring: "********"},
+		{input: "kerfuffle", wordMode: "****", substring: "****"},
+		{input: "café time", wordMode: "café time", substring: "café time"},
+		// Full-width letters are folded before matching, but unmatched ones are kept
+		{input: "ｋｅｒｆｕｆｆｌｅｓ", wordMode: "ｋｅｒｆｕｆｆｌｅｓ", substring: "****ｓ"},
+		{input: "ｘkerfuﬄeｙ", wordMode: "ｘkerfuﬄeｙ", substring: "ｘ****ｙ"},
+		{input: "ﬁkerfuffleﬁ", wordMode: "ﬁkerfuffleﬁ", substring: "ﬁ****ﬁ"},
+		{input: "cafe\u0301kerfuffle", wordMode: "cafe\u0301kerfuffle", substring: "cafe\u0301****"},
+	}
+	for _, tt := range tests {
+		if got := sanitizeChirp(tt.input, defaultProfanityReplacement, profanityMatchWord); got != tt.wordMode {
+			t.Errorf("word mode sanitizeChirp(%q) = %q, want %q", tt.input, got, tt.wordMode)
+		}
+		if got := sanitizeChirp(tt.input, defaultProfanityReplacement, profanityMatchSubstring); got != tt.substring {
+			t.Errorf("substring mode sanitizeChirp(%q) = %q, want %q", tt.input, got, tt.substring)
+		}
+	}
+
+	if containsProfanity("superkerfuffle", profanityMatchWord) {
+		t.Errorf("word mode flagged profanity inside a longer word")
+	}
+	if !containsProfanity("superkerfuffle", profanityMatchSubstring) {
+		t.Errorf("substring mode missed profanity inside a longer word")
+	}
+
+	if match, err := parseProfanityMatch(""); err != nil || match != profanityMatchWord {
+		t.Errorf("parseProfanityMatch(\"\") = %q, %v; want word", match, err)
+	}
+	if _, err := parseProfanityMatch("fuzzy"); err == nil {
+		t.Errorf("parseProfanityMatch accepted an unknown mode")
+	}
+}
+
+// lockedLoginDriver answers every query with one login_failures row locked
+// for another minute, so the lockout check is the first thing to fail.
+type lockedLoginDriver struct{}
+
+func (lockedLoginDriver) Open(string) (driver.Conn, error) { return lockedLoginConn{}, nil }
+
+type lockedLoginConn struct{}
+
+func (lockedLoginConn) Prepare(string) (driver.Stmt, error) { return lockedLoginStmt{}, nil }
+func (lockedLoginConn) Close() error                        { return nil }
+func (lockedLoginConn) Begin() (driver.Tx, error)           { return nil, errDatabaseDown }
+
+type lockedLoginStmt struct{}
+
+func (lockedLoginStmt) Close() error                               { return nil }
+func (lockedLoginStmt) NumInput() int                              { return -1 }
+func (lockedLoginStmt) Exec([]driver.Value) (driver.Result, error) { return nil, errDatabaseDown }
+func (lockedLoginStmt) Query([]driver.Value) (driver.Rows, error)  { return &lockedLoginRows{}, nil }
+
+type lockedLoginRows struct{ done bool }
+
+func (*lockedLoginRows) Columns() []string {
+	return []string{"email", "failed_attempts", "locked_until", "updated_at"}
+}
+func (*lockedLoginRows) Close() error { return nil }
+func (r *lockedLoginRows) Next(dest []driver.Value) error {
+	if r.done {
+		return io.EOF
+	}
+	r.done = true
+	now := time.Now().UTC()
+	dest[0], dest[1], dest[2], dest[3] = "a@example.com", int64(0), now.Add(time.Minute), now
+	return nil
+}
+
+func init() {
+	sql.Register("locked-login", lockedLoginDriver{})
+}
+
+func TestLockedEmailRefusedEverywhere(t *testing.T) {
+	db, err := sql.Open("locked-login", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	cfg := &apiConfig{DB: database.New(db), Conn: db, LockoutThreshold: 5, LockoutCooldown: time.Minute}
+
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+	}{
+		{name: "login", handler: cfg.loginHandler},
+		{name: "reactivate", handler: cfg.reactivateHandler},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"email":"a@example.com","password":"pw"}`))
+			req.Header.Set("Content-Type", "application/json")
+			rec := httptest.NewRecorder()
+			tt.handler(rec, req)
+
+			if rec.Code != http.StatusLocked {
+				t.Errorf("status = %d, want %d", rec.Code, http.StatusLocked)
+			}
+		})
+	}
+}
+
+func TestNonNegativeIntFromEnv(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    int
+		wantErr bool
+	}{
+		{value: "", want: 5},
+		{value: "0", want: 0},
+		{value: "3", want: 3},
+		{value: "-1", wantErr: true},
+		{value: "many", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Setenv("LOGIN_LOCKOUT_THRESHOLD", tt.value)
+		got, err := nonNegativeIntFromEnv("LOGIN_LOCKOUT_THRESHOLD", 5)
+		if (err != nil) != tt.wantErr || (!tt.wantErr && got != tt.want) {
+			t.Errorf("nonNegativeIntFromEnv with %q = %d, %v; want %d, error %v", tt.value, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestExportChirpsCSVAbortsOnDatabaseError(t *testing.T) {
+	db, err := sql.Open("failing", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	cfg := &apiConfig{DB: database.New(db), Conn: db, AdminKey: "secret"}
+
+	req := httptest.NewRequest(http.MethodGet, "/api/chirps.csv", nil)
+	req.Header.Set("Authorization", "ApiKey secret")
+	rec := httptest.NewRecorder()
+
+	defer func() {
+		if got := recover(); got != http.ErrAbortHandler {
+			t.Errorf("recovered %v, want http.ErrAbortHandler so the truncated export isn't mistaken for a complete one", got)
+		}
+	}()
+	cfg.exportChirpsCSVHandler(rec, req)
+}
+
+func TestHeadChirpChecksBlocks(t *testing.T) {
+	db, err := sql.Open("failing", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	keys, err := auth.NewKeyRing("k1", map[string]string{"k1": "test-secret"})
+	if err != nil {
+		t.Fatalf("NewKeyRing failed: %v", err)
+	}
+	cfg := &apiConfig{DB: database.New(db), Conn: db, JWTKeys: keys}
+
+	// An invalid token must be rejected before the chirp is looked up, as
+	// GET does, or HEAD would answer for chirps GET hides.
+	chirpID := uuid.NewString()
+	req := httptest.NewRequest(http.MethodHead, "/api/chirps/"+chirpID, nil)
+	req.SetPathValue("chirpID", chirpID)
+	req.Header.Set("Authorization", "Bearer not-a-jwt")
+	rec := httptest.NewRecorder()
+	cfg.headChirpHandler(rec, req)
+
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("status = %d, want %d", rec.Code, http.StatusUnauthorized)
+	}
+}
+
+// scriptedDB is a database/sql driver that answers each statement with the
+// rows scripted for the first SQL fragment it contains, and records every
+// statement it runs. Unscripted queries return no rows.
+type scriptedDB struct {
+	mu      sync.Mutex
+	scripts []scriptedQuery
+	ran     []scriptedCall
+}
+
+type scriptedQuery struct {
+	fragment string
+	rows     [][]driver.Value
+	err      error
+	// respond, when set, computes the result from the statement's arguments
+	respond func(args []driver.Value) ([][]driver.Value, error)
+}
+
+type scriptedCall struct {
+	query string
+	args  []driver.Value
+}
+
+// openScriptedDB returns a *sql.DB backed by a new scriptedDB.
+func openScriptedDB(t *testing.T) (*sql.DB, *scriptedDB) {
+	t.Helper()
+	script := &scriptedDB{}
+	db := sql.OpenDB(script)
+	t.Cleanup(func() { db.Close() })
+	return db, script
+}
+
+// on scripts the rows returned by statements containing fragment.
+func (s *scriptedDB) on(fragment string, rows ...[]driver.Value) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.scripts = append(s.scripts, scriptedQuery{fragment: fragment, rows: rows})
+}
+
+// fail makes statements containing fragment return err.
+func (s *scriptedDB) fail(fragment string, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.scripts = append(s.scripts, scriptedQuery{fragment: fragment, err: err})
+}
+
+// handle makes statements containing fragment answer with whatever respond
+// returns for their arguments, for fakes that need state.
+func (s *scriptedDB) handle(fragment string, respond func(args []driver.Value) ([][]driver.Value, error)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.scripts = append(s.scripts, scriptedQuery{fragment: fragment, respond: respond})
+}
+
+// calls returns the statements run so far that contain fragment.
+func (s *scriptedDB) calls(fragment string) []scriptedCall {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	matched := []scriptedCall{}
+	for _, call := range s.ran {
+		if strings.Contains(call.query, fragment) {
+			matched = append(matched, call)
+		}
+	}
+	return matched
+}
+
+func (s *scriptedDB) run(query string, args []driver.Value) ([][]driver.Value, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.ran = append(s.ran, scriptedCall{query: query, args: args})
+	for _, script := range s.scripts {
+		if !strings.Contains(query, script.fragment) {
+			continue
+		}
+		if script.respond != nil {
+			return script.respond(args)
+		}
+		return script.rows, script.err
+	}
+	return nil, nil
+}
+
+func (s *scriptedDB) Connect(context.Context) (driver.Conn, error) { return scriptedConn{s}, nil }
+func (s *scriptedDB) Driver() driver.Driver                        { return s }
+func (s *scriptedDB) Open(string) (driver.Conn, error)             { return scriptedConn{s}, nil }
+
+type scriptedConn struct{ db *scriptedDB }
+
+func (c scriptedConn) Prepare(query string) (driver.Stmt, error) {
+	return scriptedStmt{db: c.db, query: query}, nil
+}
+func (scriptedConn) Close() error              { return nil }
+func (scriptedConn) Begin() (driver.Tx, error) { return scriptedTx{}, nil }
+
+type scriptedTx struct{}
+
+func (scriptedTx) Commit() error   { return nil }
+func (scriptedTx) Rollback() error { return nil }
+
+type scriptedStmt struct {
+	db    *scriptedDB
+	query string
+}
+
+func (scriptedStmt) Close() error  { return nil }
+func (scriptedStmt) NumInput() int { return -1 }
+
+func (s scriptedStmt) Exec(args []driver.Value) (driver.Result, error) {
+	rows, err := s.db.run(s.query, args)
+	if err != nil {
+		return nil, err
+	}
+	if rows == nil {
+		return driver.RowsAffected(1), nil
+	}
+	return driver.RowsAffected(len(rows)), nil
+}
+
+func (s scriptedStmt) Query(args []driver.Value) (driver.Rows, error) {
+	rows, err := s.db.run(s.query, args)
+	if err != nil {
+		return nil, err
+	}
+	return &scriptedRows{rows: rows}, nil
+}
+
+type scriptedRows struct{ rows [][]driver.Value }
+
+func (r *scriptedRows) Columns() []string {
+	if len(r.rows) == 0 {
+		return nil
+	}
+	return make([]string, len(r.rows[0]))
+}
+func (*scriptedRows) Close() error { return nil }
+func (r *scriptedRows) Next(dest []driver.Value) error {
+	if len(r.rows) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.rows[0])
+	r.rows = r.rows[1:]
+	return nil
+}
+
+func TestGetChirpsExcludesBlockedAuthorsInSQL(t *testing.T) {
+	db, script := openScriptedDB(t)
+	keys, err := auth.NewKeyRing("k1", map[string]string{"k1": "test-secret"})
+	if err != nil {
+		t.Fatalf("NewKeyRing failed: %v", err)
+	}
+	token, err := keys.MakeJWT(uuid.New(), time.Hour)
+	if err != nil {
+		t.Fatalf("MakeJWT failed: %v", err)
+	}
+	blockedID := uuid.New()
+	script.on("FROM user_blocks", []driver.Value{blockedID.String()})
+	cfg := &apiConfig{DB: database.New(db), Conn: db, JWTKeys: keys}
+
+	// The filter has to be part of the query so a page's LIMIT counts only
+	// chirps the caller can see; filtering afterwards returns short pages
+	for _, query := range []string{"", "?limit=2", "?sort=desc"} {
+		req := httptest.NewRequest(http.MethodGet, "/api/chirps"+query, nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		rec := httptest.NewRecorder()
+		cfg.getChirpsHandler(rec, req)
+
+		if rec.Code != http.StatusOK {
+			t.Fatalf("GET /api/chirps%s status = %d, want %d", query, rec.Code, http.StatusOK)
+		}
+	}
+
+	calls := script.calls("FROM chirps")
+	if len(calls) != 3 {
+		t.Fatalf("ran %d chirp queries, want 3", len(calls))
+	}
+	for _, call := range calls {
+		if !strings.Contains(fmt.Sprint(call.args), blockedID.String()) {
+			t.Errorf("chirp query args %v don't exclude blocked user %s", call.args, blockedID)
+		}
+	}
+}
+
+func TestFeedChirp(t *testing.T) {
+	active := database.User{ID: uuid.New(), Active: true}
+	deactivated := database.User{ID: uuid.New()}
+	blocked := database.User{ID: uuid.New(), Active: true}
+	cache := newUserCache(10, time.Minute)
+	for _, user := range []database.User{active, deactivated, blocked} {
+		_, gen, _ := cache.get(user.ID)
+		cache.add(user, gen)
+	}
+	cfg := &apiConfig{UserCache: cache}
+	viewer := chirpViewer{id: uuid.NullUUID{UUID: uuid.New(), Valid: true}, hidden: map[uuid.UUID]bool{blocked.ID: true}}
+	public, private := database.ChirpVisibilityPublic, database.ChirpVisibilityPrivate
+
+	tests := []struct {
+		name            string
+		viewer          chirpViewer
+		chirp           Chirp
+		wantVisible     bool
+		wantUnavailable bool
+	}{
+		{name: "visible author", viewer: viewer, chirp: Chirp{UserID: active.ID, Visibility: public}, wantVisible: true},
+		{name: "blocked author", viewer: viewer, chirp: Chirp{UserID: blocked.ID, Visibility: public}},
+		{name: "deactivated author", viewer: viewer, chirp: Chirp{UserID: deactivated.ID, Visibility: public}},
+		{name: "private chirp", viewer: viewer, chirp: Chirp{UserID: active.ID, Visibility: private}},
+		{name: "own private chirp", viewer: authorViewer(active.ID), chirp: Chirp{UserID: active.ID, Visibility: private}, wantVisible: true},
+		{
+			name:            "quote of a blocked author",
+			viewer:          viewer,
+			chirp:           Chirp{UserID: active.ID, Visibility: public, QuotedChirp: &QuotedChirp{UserID: blocked.ID, Visibility: public}},
+			wantVisible:     true,
+			wantUnavailable: true,
+		},
+		{
+			name:            "quote of a private chirp",
+			viewer:          chirpViewer{},
+			chirp:           Chirp{UserID: active.ID, Visibility: public, QuotedChirp: &QuotedChirp{UserID: active.ID, Visibility: private}},
+			wantVisible:     true,
+			wantUnavailable: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, visible := cfg.feedChirp(context.Background(), tt.chirp, tt.viewer)
+			if visible != tt.wantVisible {
+				t.Fatalf("visible = %v, want %v", visible, tt.wantVisible)
+			}
+			if visible && got.QuotedChirpUnavailable != tt.wantUnavailable {
+				t.Errorf("QuotedChirpUnavailable = %v, want %v", got.QuotedChirpUnavailable, tt.wantUnavailable)
+			}
+			if tt.wantUnavailable && (got.QuotedChirp != nil || tt.chirp.QuotedChirp == nil) {
+				t.Errorf("quoted chirp still embedded, or the published chirp was modified")
+			}
+		})
+	}
+}
+
+// userRow is a users row in the column order database.User scans.
+func userRow(id uuid.UUID, email string) []driver.Value {
+	now := time.Now().UTC()
+	return []driver.Value{id.String(), now, now, email, "hash", false, int64(1), nil, "", nil, true, false}
+}
+
+// chirpRow is a chirps row in the column order database.Chirp scans.
+func chirpRow(id, authorID uuid.UUID, visibility database.ChirpVisibility) []driver.Value {
+	now := time.Now().UTC()
+	return []driver.Value{id.String(), now, now, "hello", authorID.String(), nil, string(visibility)}
+}
+
+func TestBulkGetUsersOmitsEmail(t *testing.T) {
+	db, script := openScriptedDB(t)
+	userID := uuid.New()
+	script.on("FROM users", userRow(userID, "private@example.com"))
+	cfg := &apiConfig{DB: database.New(db), Conn: db}
+
+	req := httptest.NewRequest(http.MethodPost, "/api/users/bulk-get", strings.NewReader(`["`+userID.String()+`"]`))
+	req.Header.Set("Content-Type", "application/json")
+	rec := httptest.NewRecorder()
+	cfg.bulkGetUsersHandler(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusOK)
+	}
+	var users []map[string]any
+	if err := json.Unmarshal(rec.Body.Bytes(), &users); err != nil {
+		t.Fatalf("decoding response: %v", err)
+	}
+	if len(users) != 1 {
+		t.Fatalf("got %d users, want 1", len(users))
+	}
+	if _, ok := users[0]["email"]; ok {
+		t.Errorf("anonymous bulk get exposed the email: %s", rec.Body.String())
+	}
+}
+
+func TestGetChirpAuthorOmitsEmail(t *testing.T) {
+	db, script := openScriptedDB(t)
+	chirpID, authorID := uuid.New(), uuid.New()
+	script.on("FROM chirps", chirpRow(chirpID, authorID, database.ChirpVisibilityPublic))
+	script.on("FROM users", userRow(authorID, "private@example.com"))
+	cfg := &apiConfig{DB: database.New(db), Conn: db}
+
+	req := httptest.NewRequest(http.MethodGet, "/api/chirps/"+chirpID.String()+"/author", nil)
+	req.SetPathValue("chirpID", chirpID.String())
+	rec := httptest.NewRecorder()
+	cfg.getChirpAuthorHandler(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusOK)
+	}
+	var author map[string]any
+	if err := json.Unmarshal(rec.Body.Bytes(), &author); err != nil {
+		t.Fatalf("decoding response: %v", err)
+	}
+	if author["id"] != authorID.String() {
+		t.Errorf("id = %v, want %s", author["id"], authorID)
+	}
+	if _, ok := author["email"]; ok {
+		t.Errorf("anonymous author lookup exposed the email: %s", rec.Body.String())
+	}
+}
+
+func TestExpandAuthorOmitsEmail(t *testing.T) {
+	db, script := openScriptedDB(t)
+	chirpID, authorID := uuid.New(), uuid.New()
+	script.on("FROM chirps", chirpRow(chirpID, authorID, database.ChirpVisibilityPublic))
+	script.on("FROM users", userRow(authorID, "private@example.com"))
+	cfg := &apiConfig{DB: database.New(db), Conn: db}
+
+	tests := []struct {
+		name    string
+		target  string
+		handler http.HandlerFunc
+	}{
+		{name: "single chirp", target: "/api/chirps/" + chirpID.String() + "?expand=author", handler: cfg.getChirpHandler},
+		{name: "listing", target: "/api/chirps?expand=author", handler: cfg.getChirpsHandler},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tt.target, nil)
+			req.SetPathValue("chirpID", chirpID.String())
+			rec := httptest.NewRecorder()
+			tt.handler(rec, req)
+
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status = %d, want %d", rec.Code, http.StatusOK)
+			}
+			if !strings.Contains(rec.Body.String(), `"author":{`) {
+				t.Fatalf("response has no embedded author: %s", rec.Body.String())
+			}
+			if strings.Contains(rec.Body.String(), `"email"`) {
+				t.Errorf("anonymous expand=author exposed the email: %s", rec.Body.String())
+			}
+		})
+	}
+}
+
+func TestGetChirpHidesOthersPrivateChirps(t *testing.T) {
+	db, script := openScriptedDB(t)
+	keys, err := auth.NewKeyRing("k1", map[string]string{"k1": "test-secret"})
+	if err != nil {
+		t.Fatalf("NewKeyRing failed: %v", err)
+	}
+	chirpID, authorID := uuid.New(), uuid.New()
+	script.on("FROM chirps", chirpRow(chirpID, authorID, database.ChirpVisibilityPrivate))
+	cfg := &apiConfig{DB: database.New(db), Conn: db, JWTKeys: keys}
+
+	tests := []struct {
+		name   string
+		userID uuid.UUID
+		want   int
+	}{
+		{name: "anonymous", want: http.StatusNotFound},
+		{name: "other user", userID: uuid.New(), want: http.StatusNotFound},
+		{name: "author", userID: authorID, want: http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/api/chirps/"+chirpID.String(), nil)
+			req.SetPathValue("chirpID", chirpID.String())
+			if tt.userID != uuid.Nil {
+				token, err := keys.MakeJWT(tt.userID, time.Hour)
+				if err != nil {
+					t.Fatalf("MakeJWT failed: %v", err)
+				}
+				req.Header.Set("Authorization", "Bearer "+token)
+			}
+			rec := httptest.NewRecorder()
+			cfg.getChirpHandler(rec, req)
+
+			if rec.Code != tt.want {
+				t.Errorf("status = %d, want %d", rec.Code, tt.want)
+			}
+		})
+	}
+
+	// The query itself has to know the viewer, so lists skip private chirps
+	// before their LIMIT rather than after
+	calls := script.calls("FROM chirps")
+	last := calls[len(calls)-1]
+	if !strings.Contains(fmt.Sprint(last.args), authorID.String()) {
+		t.Errorf("chirp query args %v don't carry the viewer %s", last.args, authorID)
+	}
+}
+func TestPasswordReset(t *testing.T) {
+	db, script := openScriptedDB(t)
+	userID := uuid.New()
+	now := time.Now().UTC()
+
+	// The fake applies ConsumePasswordResetToken's conditions: the token must
+	// exist, be unexpired, and be unused, and consuming it marks it used
+	tokens := map[string]*database.PasswordResetToken{
+		"valid":   {Token: "valid", UserID: userID, ExpiresAt: now.Add(time.Hour)},
+		"expired": {Token: "expired", UserID: userID, ExpiresAt: now.Add(-time.Minute)},
+		"used":    {Token: "used", UserID: userID, ExpiresAt: now.Add(time.Hour), UsedAt: sql.NullTime{Time: now, Valid: true}},
+	}
+	script.handle("UPDATE password_reset_tokens", func(args []driver.Value) ([][]driver.Value, error) {
+		token, ok := tokens[args[0].(string)]
+		if !ok || !token.ExpiresAt.After(time.Now()) || token.UsedAt.Valid {
+			return nil, nil
+		}
+		token.UsedAt = sql.NullTime{Time: time.Now().UTC(), Valid: true}
+		return [][]driver.Value{{token.Token, now, now, token.UserID.String(), token.ExpiresAt, token.UsedAt.Time}}, nil
+	})
+	script.on("UPDATE users", userRow(userID, "a@example.com"))
+	cfg := &apiConfig{DB: database.New(db), Conn: db}
+
+	// Run in order: the reuse case relies on the success before it
+	tests := []struct {
+		name  string
+		token string
+		want  int
+	}{
+		{name: "unknown token", token: "missing", want: http.StatusBadRequest},
+		{name: "expired token", token: "expired", want: http.StatusBadRequest},
+		{name: "used token", token: "used", want: http.StatusBadRequest},
+		{name: "valid token", token: "valid", want: http.StatusOK},
+		{name: "valid token reused", token: "valid", want: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body := `{"token":"` + tt.token + `","new_password":"new-password"}`
+			req := httptest.NewRequest(http.MethodPost, "/api/password/reset", strings.NewReader(body))
+			req.Header.Set("Content-Type", "application/json")
+			rec := httptest.NewRecorder()
+			cfg.passwordResetHandler(rec, req)
+
+			if rec.Code != tt.want {
+				t.Errorf("status = %d, want %d: %s", rec.Code, tt.want, rec.Body.String())
+			}
+		})
+	}
+
+	if got := len(script.calls("UPDATE users")); got != 1 {
+		t.Errorf("password updated %d times, want once", got)
+	}
+	if got := len(script.calls("UPDATE refresh_tokens")); got != 1 {
+		t.Errorf("sessions revoked %d times, want once", got)
+	}
+}
+
+func TestPasswordResetRequest(t *testing.T) {
+	db, script := openScriptedDB(t)
+	userID := uuid.New()
+	now := time.Now().UTC()
+	script.on("WHERE email = $1", userRow(userID, "known@example.com"))
+	script.handle("INSERT INTO password_reset_tokens", func(args []driver.Value) ([][]driver.Value, error) {
+		return [][]driver.Value{{args[0], now, now, userID.String(), args[4], nil}}, nil
+	})
+	cfg := &apiConfig{DB: database.New(db), Conn: db}
+
+	req := httptest.NewRequest(http.MethodPost, "/api/password/reset-request", strings.NewReader(`{"email":"known@example.com"}`))
+	req.Header.Set("Content-Type", "application/json")
+	rec := httptest.NewRecorder()
+	cfg.passwordResetRequestHandler(rec, req)
+
+	if rec.Code != http.StatusAccepted {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusAccepted)
+	}
+	inserts := script.calls("INSERT INTO password_reset_tokens")
+	if len(inserts) != 1 {
+		t.Fatalf("issued %d reset tokens, want 1", len(inserts))
+	}
+	if token, _ := inserts[0].args[0].(string); len(token) != 64 {
+		t.Errorf("reset token %q is not 256 random bits", token)
+	}
+	if expiresAt, _ := inserts[0].args[4].(time.Time); !expiresAt.After(now) || expiresAt.After(now.Add(passwordResetTokenTTL+time.Minute)) {
+		t.Errorf("reset token expires at %v, want about %v from now", expiresAt, passwordResetTokenTTL)
+	}
+
+	// Unknown emails get the same answer, so the endpoint can't reveal accounts
+	unknownDB, unknownScript := openScriptedDB(t)
+	cfg = &apiConfig{DB: database.New(unknownDB), Conn: unknownDB}
+	req = httptest.NewRequest(http.MethodPost, "/api/password/reset-request", strings.NewReader(`{"email":"nobody@example.com"}`))
+	req.Header.Set("Content-Type", "application/json")
+	rec = httptest.NewRecorder()
+	cfg.passwordResetRequestHandler(rec, req)
+
+	if rec.Code != http.StatusAccepted {
+		t.Errorf("unknown email status = %d, want %d", rec.Code, http.StatusAccepted)
+	}
+	if got := len(unknownScript.calls("INSERT INTO password_reset_tokens")); got != 0 {
+		t.Errorf("issued %d reset tokens for an unknown email, want 0", got)
+	}
+}
@@ -0,0 +1,420 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"runtime/debug"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// contextKey is an unexported type for context keys defined in this package.
+type contextKey string
+
+const requestIDKey contextKey = "request_id"
+
+const (
+	// requestIDHeader is the header used to propagate request IDs.
+	requestIDHeader = "X-Request-ID"
+	// maxRequestIDLength bounds caller-supplied IDs so they can't bloat log lines.
+	maxRequestIDLength = 128
+)
+
+// middlewareRequestID tags each request with an ID, reusing the caller's if provided.
+func middlewareRequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestID := r.Header.Get(requestIDHeader)
+		if requestID == "" || len(requestID) > maxRequestIDLength {
+			requestID = uuid.New().String()
+		}
+
+		w.Header().Set(requestIDHeader, requestID)
+		ctx := context.WithValue(r.Context(), requestIDKey, requestID)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// requestIDFromContext returns the request ID stored by middlewareRequestID, if any.
+func requestIDFromContext(ctx context.Context) string {
+	requestID, _ := ctx.Value(requestIDKey).(string)
+	return requestID
+}
+
+const (
+	corsAllowMethods  = "GET, HEAD, POST, PUT, DELETE, OPTIONS"
+	corsAllowHeaders  = "Authorization, Content-Type, If-Match, If-None-Match, Idempotency-Key, X-Request-ID"
+	corsExposeHeaders = "ETag, Location, Retry-After, X-Request-ID"
+	corsMaxAge        = "600"
+)
+
+// corsPolicy decides which browser origins may call the API.
+type corsPolicy struct {
+	allowedOrigins   map[string]bool
+	allowAnyOrigin   bool
+	allowCredentials bool
+}
+
+// newCORSPolicy parses a comma-separated origin allowlist, where "*" allows any
+// origin. Credentialed CORS can't be combined with "*": browsers refuse it, and
+// echoing every origin instead would let any site act as a signed-in user.
+func newCORSPolicy(origins string, allowCredentials bool) (*corsPolicy, error) {
+	policy := &corsPolicy{
+		allowedOrigins:   map[string]bool{},
+		allowCredentials: allowCredentials,
+	}
+
+	for _, origin := range strings.Split(origins, ",") {
+		origin = strings.TrimSpace(origin)
+		switch origin {
+		case "":
+			continue
+		case "*":
+			policy.allowAnyOrigin = true
+		default:
+			policy.allowedOrigins[origin] = true
+		}
+	}
+
+	if policy.allowAnyOrigin && allowCredentials {
+		return nil, errors.New("CORS credentials require an explicit origin allowlist, not *")
+	}
+
+	return policy, nil
+}
+
+// allowsOrigin reports whether a browser page on origin may call the API. A
+// nil policy allows no cross-origin callers.
+func (p *corsPolicy) allowsOrigin(origin string) bool {
+	return p != nil && (p.allowAnyOrigin || p.allowedOrigins[origin])
+}
+
+// checkWebSocketOrigin is a websocket.Upgrader CheckOrigin that accepts the
+// same browser origins as CORS. Handshakes without an Origin header come from
+// non-browser clients and same-origin ones are always fine, as in gorilla's
+// default check.
+func (p *corsPolicy) checkWebSocketOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+
+	u, err := url.Parse(origin)
+	if err == nil && strings.EqualFold(u.Host, r.Host) {
+		return true
+	}
+	return p.allowsOrigin(origin)
+}
+
+// middleware adds CORS headers for allowed origins and answers preflight requests.
+// Disallowed origins get no CORS headers, so browsers block the response.
+func (p *corsPolicy) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if origin == "" {
+			// Not a cross-origin browser request
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// The response depends on Origin, so caches must key on it
+		w.Header().Add("Vary", "Origin")
+
+		allowed := p.allowsOrigin(origin)
+		if allowed {
+			if p.allowCredentials {
+				w.Header().Set("Access-Control-Allow-Origin", origin)
+				w.Header().Set("Access-Control-Allow-Credentials", "true")
+			} else if p.allowAnyOrigin {
+				w.Header().Set("Access-Control-Allow-Origin", "*")
+			} else {
+				w.Header().Set("Access-Control-Allow-Origin", origin)
+			}
+			w.Header().Set("Access-Control-Expose-Headers", corsExposeHeaders)
+		}
+
+		isPreflight := r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != ""
+		if isPreflight {
+			if allowed {
+				w.Header().Set("Access-Control-Allow-Methods", corsAllowMethods)
+				w.Header().Set("Access-Control-Allow-Headers", corsAllowHeaders)
+				w.Header().Set("Access-Control-Max-Age", corsMaxAge)
+			}
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// streamingPaths are long-lived endpoints that write their response
+// incrementally. They must outlive the request timeout and need the
+// server's own ResponseWriter for flushing and hijacking.
+var streamingPaths = []string{"/api/chirps/stream", "/api/ws", "/api/chirps.csv", "/debug/pprof/"}
+
+// isStreamingPath reports whether path is served by a streaming endpoint.
+func isStreamingPath(path string) bool {
+	for _, prefix := range streamingPaths {
+		if strings.HasPrefix(path, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// middlewareTimeout cancels the request context after timeout so a slow query
+// can't hold a request open forever. Handlers report a cancelled query as a
+// 5xx; once the deadline has passed that is rewritten as a 504.
+func middlewareTimeout(timeout time.Duration, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if isStreamingPath(r.URL.Path) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), timeout)
+		defer cancel()
+
+		next.ServeHTTP(&timeoutWriter{ResponseWriter: w, ctx: ctx}, r.WithContext(ctx))
+	})
+}
+
+// timeoutWriter replaces a server error written after the deadline with a 504
+// and drops the handler's own body.
+type timeoutWriter struct {
+	http.ResponseWriter
+	ctx         context.Context
+	wroteHeader bool
+	timedOut    bool
+}
+
+func (tw *timeoutWriter) WriteHeader(status int) {
+	if tw.wroteHeader {
+		return
+	}
+	tw.wroteHeader = true
+
+	if status >= http.StatusInternalServerError && errors.Is(tw.ctx.Err(), context.DeadlineExceeded) {
+		tw.timedOut = true
+		respondWithErrorCode(tw.ResponseWriter, http.StatusGatewayTimeout, codeTimeout, "Request timed out")
+		return
+	}
+	tw.ResponseWriter.WriteHeader(status)
+}
+
+func (tw *timeoutWriter) Write(b []byte) (int, error) {
+	if !tw.wroteHeader {
+		tw.WriteHeader(http.StatusOK)
+	}
+	if tw.timedOut {
+		return len(b), nil
+	}
+	return tw.ResponseWriter.Write(b)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (tw *timeoutWriter) Unwrap() http.ResponseWriter {
+	return tw.ResponseWriter
+}
+
+// middlewareNoDotfiles answers 404 for any path with a component starting
+// with a dot, so files like .env or .git are never served even if present.
+func middlewareNoDotfiles(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for _, part := range strings.Split(r.URL.Path, "/") {
+			if strings.HasPrefix(part, ".") {
+				http.NotFound(w, r)
+				return
+			}
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// concurrencyRetryAfter is the Retry-After hint, in seconds, sent when the server is saturated.
+const concurrencyRetryAfter = "1"
+
+// middlewareConcurrencyLimit caps in-flight requests at limit, answering 503
+// straight away once every slot is taken rather than queueing without bound.
+// Open streams and websockets hold a slot for as long as they stay connected.
+func middlewareConcurrencyLimit(limit int, next http.Handler) http.Handler {
+	slots := make(chan struct{}, limit)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case slots <- struct{}{}:
+		default:
+			w.Header().Set("Retry-After", concurrencyRetryAfter)
+			respondWithErrorCode(w, http.StatusServiceUnavailable, codeServerBusy, "Server is busy, try again shortly")
+			return
+		}
+		// Deferred so the slot comes back even if the handler panics
+		defer func() { <-slots }()
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// middlewareRecover turns a handler panic into a logged stack trace and a 500,
+// instead of the connection dying with no response.
+func middlewareRecover(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			rec := recover()
+			if rec == nil {
+				return
+			}
+			// ErrAbortHandler is how handlers deliberately abort a response
+			if rec == http.ErrAbortHandler {
+				panic(rec)
+			}
+
+			slog.Error("Handler panicked",
+				"request_id", requestIDFromContext(r.Context()),
+				"method", r.Method,
+				"path", r.URL.Path,
+				"panic", rec,
+				"stack", string(debug.Stack()),
+			)
+			respondWithErrorCode(w, http.StatusInternalServerError, codeInternalError, "Internal server error")
+		}()
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// middlewarePrettyJSON indents JSON responses when the request has
+// ?pretty=true, for reading them with curl. Responses stay compact otherwise.
+// It works on the response rather than the request context because
+// respondWithJSON only sees the ResponseWriter.
+func middlewarePrettyJSON(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("pretty") != "true" || isStreamingPath(r.URL.Path) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		next.ServeHTTP(&prettyJSONWriter{ResponseWriter: w}, r)
+	})
+}
+
+// prettyJSONWriter indents each JSON write; respondWithJSON writes the whole
+// body at once. Other content types pass through untouched.
+type prettyJSONWriter struct {
+	http.ResponseWriter
+}
+
+func (pw *prettyJSONWriter) Write(b []byte) (int, error) {
+	if !strings.HasPrefix(pw.Header().Get("Content-Type"), "application/json") {
+		return pw.ResponseWriter.Write(b)
+	}
+
+	var buf bytes.Buffer
+	if err := json.Indent(&buf, b, "", "  "); err != nil {
+		return pw.ResponseWriter.Write(b)
+	}
+	buf.WriteByte('\n')
+
+	if _, err := pw.ResponseWriter.Write(buf.Bytes()); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (pw *prettyJSONWriter) Unwrap() http.ResponseWriter {
+	return pw.ResponseWriter
+}
+
+// maxLoggedBodyBytes caps how much of each body middlewareDevBodyLog logs.
+const maxLoggedBodyBytes = 64 << 10
+
+// middlewareDevBodyLog logs request and response bodies for local debugging.
+// Bodies carry passwords, tokens, and emails, so outside the dev platform it
+// returns next unwrapped. Streaming endpoints are skipped since their
+// responses never finish.
+func middlewareDevBodyLog(platform string, next http.Handler) http.Handler {
+	if platform != "dev" {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if isStreamingPath(r.URL.Path) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// Copy the request body as the handler reads it rather than up front,
+		// so a large upload is never held in memory past the logging cap
+		br := &bodyLogReader{ReadCloser: r.Body}
+		r.Body = br
+
+		bw := &bodyLogWriter{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(bw, r)
+
+		slog.Info("Request bodies",
+			"request_id", requestIDFromContext(r.Context()),
+			"method", r.Method,
+			"path", r.URL.Path,
+			"status", bw.status,
+			"request_body", truncateLoggedBody(br.body.Bytes()),
+			"response_body", truncateLoggedBody(bw.body.Bytes()),
+		)
+	})
+}
+
+// bodyLogReader passes reads through while keeping a capped copy of what
+// was read. Bytes the handler never reads aren't logged.
+type bodyLogReader struct {
+	io.ReadCloser
+	body bytes.Buffer
+}
+
+func (br *bodyLogReader) Read(p []byte) (int, error) {
+	n, err := br.ReadCloser.Read(p)
+	if room := maxLoggedBodyBytes + 1 - br.body.Len(); room > 0 {
+		br.body.Write(p[:min(n, room)])
+	}
+	return n, err
+}
+
+// bodyLogWriter passes writes through while keeping a capped copy of the body.
+type bodyLogWriter struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (bw *bodyLogWriter) WriteHeader(status int) {
+	bw.status = status
+	bw.ResponseWriter.WriteHeader(status)
+}
+
+func (bw *bodyLogWriter) Write(b []byte) (int, error) {
+	if room := maxLoggedBodyBytes + 1 - bw.body.Len(); room > 0 {
+		bw.body.Write(b[:min(len(b), room)])
+	}
+	return bw.ResponseWriter.Write(b)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (bw *bodyLogWriter) Unwrap() http.ResponseWriter {
+	return bw.ResponseWriter
+}
+
+// truncateLoggedBody returns b as a string, cut to maxLoggedBodyBytes.
+func truncateLoggedBody(b []byte) string {
+	if len(b) > maxLoggedBodyBytes {
+		return string(b[:maxLoggedBodyBytes]) + "...(truncated)"
+	}
+	return string(b)
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"bytes"
+	"chirpy/internal/auth"
+	"chirpy/internal/database"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	// outboundWebhookTimeout bounds each delivery attempt.
+	outboundWebhookTimeout = 5 * time.Second
+	// outboundWebhookRetries is how many times a failed delivery is retried.
+	outboundWebhookRetries = 2
+	// outboundWebhookBackoff is the delay before the first retry; it doubles each time.
+	outboundWebhookBackoff = time.Second
+)
+
+// outboundWebhookSignatureHeader carries the HMAC of the body; see auth.SignWebhookPayload.
+const outboundWebhookSignatureHeader = "X-Chirpy-Signature"
+
+// outboundWebhook delivers JSON events to an external URL, such as new
+// chirps to a subscriber or account deletions to billing.
+type outboundWebhook struct {
+	url string
+	// signingSecret, when set, is used to sign every request body so
+	// subscribers can verify it came from us
+	signingSecret string
+	client        *http.Client
+}
+
+// newOutboundWebhook returns a webhook that POSTs to url, signing requests
+// with signingSecret unless it is empty.
+func newOutboundWebhook(url, signingSecret string) *outboundWebhook {
+	return &outboundWebhook{
+		url:           url,
+		signingSecret: signingSecret,
+		client:        &http.Client{Timeout: outboundWebhookTimeout},
+	}
+}
+
+// Notify delivers payload as JSON in the background. Failures are logged,
+// with logArgs for context, and never surface to the request that triggered
+// the event. A nil webhook does nothing.
+func (wh *outboundWebhook) Notify(payload any, logArgs ...any) {
+	if wh == nil {
+		return
+	}
+
+	go func() {
+		err := wh.deliver(payload)
+		if err != nil {
+			slog.Warn("Failed to deliver webhook", append(logArgs, "url", wh.url, "error", err)...)
+		}
+	}()
+}
+
+// billingEvent is the body sent to the billing webhook, mirroring the shape
+// of the events Polka sends us.
+type billingEvent struct {
+	Event string `json:"event"`
+	Data  struct {
+		UserID uuid.UUID `json:"user_id"`
+	} `json:"data"`
+}
+
+// NotifyUserDeleted tells billing that an account is gone. A delivery that
+// still fails after retries is logged as an error for manual reconciliation.
+// A nil webhook does nothing.
+func (wh *outboundWebhook) NotifyUserDeleted(userID uuid.UUID) {
+	if wh == nil {
+		return
+	}
+
+	event := billingEvent{Event: "user.deleted"}
+	event.Data.UserID = userID
+
+	go func() {
+		err := wh.deliver(event)
+		if err != nil {
+			slog.Error("Failed to notify billing of deleted user; reconcile manually", "user_id", userID, "error", err)
+		}
+	}()
+}
+
+// passwordResetEvent is the body sent to the password reset webhook, which
+// is expected to email the token to the user.
+type passwordResetEvent struct {
+	Event string `json:"event"`
+	Data  struct {
+		UserID    uuid.UUID `json:"user_id"`
+		Email     string    `json:"email"`
+		Token     string    `json:"token"`
+		ExpiresAt time.Time `json:"expires_at"`
+	} `json:"data"`
+}
+
+// NotifyPasswordResetRequested hands a newly issued reset token to the mailer.
+// Failures are logged without the token. A nil webhook does nothing.
+func (wh *outboundWebhook) NotifyPasswordResetRequested(resetToken database.PasswordResetToken, email string) {
+	if wh == nil {
+		return
+	}
+
+	event := passwordResetEvent{Event: "password_reset.requested"}
+	event.Data.UserID = resetToken.UserID
+	event.Data.Email = email
+	event.Data.Token = resetToken.Token
+	event.Data.ExpiresAt = resetToken.ExpiresAt
+
+	go func() {
+		err := wh.deliver(event)
+		if err != nil {
+			slog.Warn("Failed to deliver password reset email", "user_id", resetToken.UserID, "url", wh.url, "error", err)
+		}
+	}()
+}
+
+// deliver POSTs payload as JSON, retrying with backoff on errors and non-2xx responses.
+func (wh *outboundWebhook) deliver(payload any) error {
+	dat, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	backoff := outboundWebhookBackoff
+	for attempt := 0; ; attempt++ {
+		err = wh.post(dat)
+		if err == nil || attempt == outboundWebhookRetries {
+			return err
+		}
+
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// post makes a single delivery attempt.
+func (wh *outboundWebhook) post(dat []byte) error {
+	req, err := http.NewRequest(http.MethodPost, wh.url, bytes.NewReader(dat))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if wh.signingSecret != "" {
+		req.Header.Set(outboundWebhookSignatureHeader, auth.SignWebhookPayload(dat, wh.signingSecret))
+	}
+
+	resp, err := wh.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	// Drain the body so the connection can be reused
+	io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook responded with status %d", resp.StatusCode)
+	}
+
+	return nil
+}
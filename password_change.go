@@ -0,0 +1,140 @@
+package main
+
+import (
+	"chirpy/internal/auth"
+	"chirpy/internal/database"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// passwordChangeBody represents the expected JSON request body for a password change.
+type passwordChangeBody struct {
+	CurrentPassword string `json:"current_password"`
+	NewPassword     string `json:"new_password"`
+}
+
+// passwordChangeResponse returns the updated user with a fresh refresh token,
+// since every refresh token issued before the change is revoked.
+type passwordChangeResponse struct {
+	User
+	RefreshToken          string    `json:"refresh_token"`
+	RefreshTokenExpiresAt time.Time `json:"refresh_token_expires_at"`
+}
+
+// passwordChangeHandler sets a new password for the authenticated user after
+// re-checking the current one, so a briefly stolen JWT isn't enough to take
+// over the account.
+func (cfg *apiConfig) passwordChangeHandler(w http.ResponseWriter, r *http.Request) {
+	tokenString, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithErrorCode(w, http.StatusUnauthorized, codeMissingToken, "Couldn't find JWT")
+		return
+	}
+
+	userID, err := cfg.JWTKeys.ValidateJWT(tokenString)
+	if err != nil {
+		respondWithErrorCode(w, http.StatusUnauthorized, codeInvalidToken, "Invalid JWT")
+		return
+	}
+
+	if !requireJSONContentType(w, r) {
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	var reqBody passwordChangeBody
+
+	err = decoder.Decode(&reqBody)
+	if err != nil {
+		respondWithErrorCode(w, http.StatusBadRequest, codeInvalidPayload, "Invalid request payload")
+		return
+	}
+
+	if reqBody.CurrentPassword == "" || reqBody.NewPassword == "" {
+		respondWithErrorCode(w, http.StatusUnprocessableEntity, codeInvalidPayload, "Current and new password are required")
+		return
+	}
+
+	dbUser, err := cfg.DB.GetUserByID(r.Context(), userID)
+	if err != nil {
+		// The JWT may outlive the account it was issued for
+		if err == sql.ErrNoRows {
+			respondWithErrorCode(w, http.StatusUnauthorized, codeUserDeleted, "User no longer exists")
+			return
+		}
+		respondWithErrorCode(w, http.StatusInternalServerError, codeInternalError, "Failed to retrieve user")
+		return
+	}
+
+	err = auth.CheckPasswordHash(reqBody.CurrentPassword, dbUser.HashedPassword)
+	if err != nil {
+		respondWithErrorCode(w, http.StatusUnauthorized, codeInvalidCredentials, "Incorrect current password")
+		return
+	}
+
+	hashedPassword, err := auth.HashPassword(reqBody.NewPassword)
+	if err != nil {
+		respondWithErrorCode(w, http.StatusInternalServerError, codeInternalError, "Failed to hash password")
+		return
+	}
+
+	refreshToken, err := auth.MakeRefreshToken()
+	if err != nil {
+		respondWithErrorCode(w, http.StatusInternalServerError, codeInternalError, "Failed to create refresh token")
+		return
+	}
+
+	now := time.Now().UTC()
+	refreshTokenExpiresAt := now.Add(refreshTokenTTL)
+
+	// A JWT doesn't say which session it came from, so revoke every existing
+	// refresh token and hand the caller a new one to stay signed in with
+	var updatedUser database.User
+	err = cfg.withTx(r.Context(), func(q *database.Queries) error {
+		var txErr error
+		updatedUser, txErr = q.UpdateUserPassword(r.Context(), database.UpdateUserPasswordParams{
+			ID:             userID,
+			HashedPassword: hashedPassword,
+			UpdatedAt:      now,
+		})
+		if txErr != nil {
+			return txErr
+		}
+
+		txErr = q.RevokeRefreshTokensForUser(r.Context(), userID)
+		if txErr != nil {
+			return txErr
+		}
+
+		_, txErr = q.CreateRefreshToken(r.Context(), database.CreateRefreshTokenParams{
+			Token:     refreshToken,
+			CreatedAt: now,
+			UpdatedAt: now,
+			UserID:    userID,
+			ExpiresAt: refreshTokenExpiresAt,
+			FamilyID:  uuid.New(),
+		})
+		return txErr
+	})
+	cfg.UserCache.Invalidate(userID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			respondWithErrorCode(w, http.StatusUnauthorized, codeUserDeleted, "User no longer exists")
+			return
+		}
+		respondWithErrorCode(w, http.StatusInternalServerError, codeInternalError, "Failed to change password")
+		return
+	}
+
+	w.Header().Set("ETag", userETag(updatedUser.Version))
+	respondWithJSON(w, http.StatusOK, passwordChangeResponse{
+		User:                  databaseUserToUser(updatedUser),
+		RefreshToken:          refreshToken,
+		RefreshTokenExpiresAt: refreshTokenExpiresAt,
+	})
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"chirpy/internal/auth"
+	"chirpy/internal/database"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// passwordResetTokenTTL is how long a reset token can be used after it's issued.
+const passwordResetTokenTTL = time.Hour
+
+// passwordResetRequestBody represents the expected JSON request body for
+// requesting a password reset.
+type passwordResetRequestBody struct {
+	Email string `json:"email"`
+}
+
+// passwordResetRequestHandler issues a reset token for the account with the
+// given email and hands it to the password reset webhook to mail out. It
+// answers 202 whether or not the email is registered, so it can't be used to
+// find out who has an account.
+func (cfg *apiConfig) passwordResetRequestHandler(w http.ResponseWriter, r *http.Request) {
+	if !requireJSONContentType(w, r) {
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	var reqBody passwordResetRequestBody
+
+	err := decoder.Decode(&reqBody)
+	if err != nil {
+		respondWithErrorCode(w, http.StatusBadRequest, codeInvalidPayload, "Invalid request payload")
+		return
+	}
+
+	if reqBody.Email == "" {
+		respondWithErrorCode(w, http.StatusUnprocessableEntity, codeInvalidPayload, "Email is required")
+		return
+	}
+
+	dbUser, err := cfg.DB.GetUserByEmail(r.Context(), reqBody.Email)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			w.WriteHeader(http.StatusAccepted)
+			return
+		}
+		respondWithErrorCode(w, http.StatusInternalServerError, codeInternalError, "Failed to retrieve user")
+		return
+	}
+
+	// Reset tokens are random strings like refresh tokens, just short-lived
+	token, err := auth.MakeRefreshToken()
+	if err != nil {
+		respondWithErrorCode(w, http.StatusInternalServerError, codeInternalError, "Failed to create reset token")
+		return
+	}
+
+	now := time.Now().UTC()
+	resetToken, err := cfg.DB.CreatePasswordResetToken(r.Context(), database.CreatePasswordResetTokenParams{
+		Token:     token,
+		CreatedAt: now,
+		UpdatedAt: now,
+		UserID:    dbUser.ID,
+		ExpiresAt: now.Add(passwordResetTokenTTL),
+	})
+	if err != nil {
+		respondWithErrorCode(w, http.StatusInternalServerError, codeInternalError, "Failed to create reset token")
+		return
+	}
+
+	if cfg.PasswordResetWebhook == nil && cfg.Platform == "dev" {
+		// Nothing would deliver the token, so log it for local testing
+		slog.Info("Password reset token issued", "user_id", dbUser.ID, "token", resetToken.Token)
+	}
+	cfg.PasswordResetWebhook.NotifyPasswordResetRequested(resetToken, dbUser.Email)
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// passwordResetBody represents the expected JSON request body for a password reset.
+type passwordResetBody struct {
+	Token       string `json:"token"`
+	NewPassword string `json:"new_password"`
+}
+
+// passwordResetHandler consumes a reset token and sets the user's new password.
+func (cfg *apiConfig) passwordResetHandler(w http.ResponseWriter, r *http.Request) {
+	if !requireJSONContentType(w, r) {
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	var reqBody passwordResetBody
+
+	err := decoder.Decode(&reqBody)
+	if err != nil {
+		respondWithErrorCode(w, http.StatusBadRequest, codeInvalidPayload, "Invalid request payload")
+		return
+	}
+
+	if reqBody.Token == "" || reqBody.NewPassword == "" {
+		respondWithErrorCode(w, http.StatusUnprocessableEntity, codeInvalidPayload, "Token and new password are required")
+		return
+	}
+
+	// Hash before consuming the token so a hashing failure doesn't burn it
+	hashedPassword, err := auth.HashPassword(reqBody.NewPassword)
+	if err != nil {
+		respondWithErrorCode(w, http.StatusInternalServerError, codeInternalError, "Failed to hash password")
+		return
+	}
+
+	// Consume the token, update the password, and revoke sessions atomically so a
+	// failure part-way through never leaves a burned token with the old password
+	var updatedUser database.User
+	err = cfg.withTx(r.Context(), func(q *database.Queries) error {
+		// Consuming is a single conditional update, so a token can only be used once
+		resetToken, txErr := q.ConsumePasswordResetToken(r.Context(), reqBody.Token)
+		if txErr != nil {
+			return txErr
+		}
+
+		updatedUser, txErr = q.UpdateUserPassword(r.Context(), database.UpdateUserPasswordParams{
+			ID:             resetToken.UserID,
+			HashedPassword: hashedPassword,
+			UpdatedAt:      time.Now().UTC(),
+		})
+		if txErr != nil {
+			return txErr
+		}
+
+		// Kill any existing sessions in case they were opened by whoever knew the old password
+		return q.RevokeRefreshTokensForUser(r.Context(), updatedUser.ID)
+	})
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			respondWithErrorCode(w, http.StatusBadRequest, codeInvalidResetToken, "Invalid, expired, or used reset token")
+			return
+		}
+		respondWithErrorCode(w, http.StatusInternalServerError, codeInternalError, "Failed to reset password")
+		return
+	}
+	cfg.UserCache.Invalidate(updatedUser.ID)
+
+	user := databaseUserToUser(updatedUser)
+
+	respondWithJSON(w, http.StatusOK, user)
+}
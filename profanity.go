@@ -0,0 +1,202 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// profanityMode decides what happens to chirps containing profanity.
+type profanityMode string
+
+const (
+	// profanityCensor replaces profane words before the chirp is stored.
+	profanityCensor profanityMode = "censor"
+	// profanityFlag stores the chirp as written and marks it with contains_profanity.
+	profanityFlag profanityMode = "flag"
+)
+
+// parseProfanityMode reads a PROFANITY_MODE value, defaulting to censor.
+func parseProfanityMode(s string) (profanityMode, error) {
+	switch mode := profanityMode(s); mode {
+	case "":
+		return profanityCensor, nil
+	case profanityCensor, profanityFlag:
+		return mode, nil
+	default:
+		return "", fmt.Errorf("PROFANITY_MODE must be %q or %q", profanityCensor, profanityFlag)
+	}
+}
+
+// defaultProfanityReplacement is what profane words become when
+// PROFANITY_REPLACEMENT is unset.
+const defaultProfanityReplacement = "****"
+
+// profanityMatch decides which parts of a word are checked against profaneWords.
+type profanityMatch string
+
+const (
+	// profanityMatchWord only matches whole space-separated words.
+	profanityMatchWord profanityMatch = "word"
+	// profanityMatchSubstring also matches profane words inside longer tokens,
+	// like "superkerfuffle", and replaces just the matched part. It catches
+	// more evasions, but also innocent words that happen to contain a
+	// profane one (the Scunthorpe problem), which is why it isn't the default.
+	profanityMatchSubstring profanityMatch = "substring"
+)
+
+// parseProfanityMatch reads a PROFANITY_MATCH_MODE value, defaulting to word.
+func parseProfanityMatch(s string) (profanityMatch, error) {
+	switch match := profanityMatch(s); match {
+	case "":
+		return profanityMatchWord, nil
+	case profanityMatchWord, profanityMatchSubstring:
+		return match, nil
+	default:
+		return "", fmt.Errorf("PROFANITY_MATCH_MODE must be %q or %q", profanityMatchWord, profanityMatchSubstring)
+	}
+}
+
+var profaneWords = []string{"kerfuffle", "sharbert", "fornax"}
+
+// maskWord replaces profanity in a single space-separated word and reports
+// whether there was any. The zero profanityMatch matches whole words.
+func maskWord(word, replacement string, match profanityMatch) (string, bool) {
+	// NFKC folds compatibility forms, such as full-width letters, to their
+	// plain equivalents so they can't be used to dodge the filter
+	cleanedWord := norm.NFKC.String(word)
+
+	if match != profanityMatchSubstring {
+		if slices.ContainsFunc(profaneWords, func(profaneWord string) bool {
+			return strings.EqualFold(cleanedWord, profaneWord)
+		}) {
+			return replacement, true
+		}
+		return word, false
+	}
+
+	// Matches are found in the normalized form, but unmatched text is copied
+	// from the original so only the profanity itself changes
+	segments := splitNFKC(word)
+	var masked strings.Builder
+	found := false
+	copied := 0
+	for i := 0; i < len(cleanedWord); {
+		n := profaneWordLenAt(cleanedWord, i)
+		if n == 0 {
+			_, size := utf8.DecodeRuneInString(cleanedWord[i:])
+			i += size
+			continue
+		}
+
+		// A match that starts or ends partway through a segment, such as
+		// one letter of a ligature, takes the whole segment
+		first, last := segments.at(i), segments.at(i+n-1)
+		masked.WriteString(word[copied:max(copied, first.origStart)])
+		masked.WriteString(replacement)
+		copied = last.origEnd
+		found = true
+		i = last.end
+	}
+	if !found {
+		return word, false
+	}
+	masked.WriteString(word[copied:])
+	return masked.String(), true
+}
+
+// nfkcSegment maps the bytes [start, end) of a word's NFKC form back to the
+// bytes [origStart, origEnd) of the word they came from.
+type nfkcSegment struct {
+	start, end         int
+	origStart, origEnd int
+}
+
+type nfkcSegments []nfkcSegment
+
+// splitNFKC splits word into the smallest pieces NFKC normalizes
+// independently. Joining their normalized forms gives norm.NFKC.String(word).
+func splitNFKC(word string) nfkcSegments {
+	segments := nfkcSegments{}
+	var iter norm.Iter
+	iter.InitString(norm.NFKC, word)
+	start, origStart := 0, 0
+	for !iter.Done() {
+		normalized := iter.Next()
+		segments = append(segments, nfkcSegment{
+			start:     start,
+			end:       start + len(normalized),
+			origStart: origStart,
+			origEnd:   iter.Pos(),
+		})
+		start += len(normalized)
+		origStart = iter.Pos()
+	}
+	return segments
+}
+
+// at returns the segment holding byte offset i of the normalized word.
+func (segments nfkcSegments) at(i int) nfkcSegment {
+	for _, segment := range segments {
+		if i < segment.end {
+			return segment
+		}
+	}
+	return segments[len(segments)-1]
+}
+
+// profaneWordLenAt returns the byte length of the profane word starting at
+// byte offset i of s, or 0 if none does.
+func profaneWordLenAt(s string, i int) int {
+	for _, profaneWord := range profaneWords {
+		end := i + len(profaneWord)
+		if end <= len(s) && strings.EqualFold(s[i:end], profaneWord) {
+			return len(profaneWord)
+		}
+	}
+	return 0
+}
+
+// containsProfanity reports whether s has any profanity.
+func containsProfanity(s string, match profanityMatch) bool {
+	return slices.ContainsFunc(strings.Split(s, " "), func(word string) bool {
+		_, found := maskWord(word, "", match)
+		return found
+	})
+}
+
+// sanitizeChirp replaces profanity in a given string with replacement.
+func sanitizeChirp(s, replacement string, match profanityMatch) string {
+	words := strings.Split(s, " ")
+
+	for i, word := range words {
+		words[i], _ = maskWord(word, replacement, match)
+	}
+
+	return strings.Join(words, " ")
+}
+
+// chirpCensorReplacement returns what validateChirp should mask profanity
+// with: the configured replacement in censor mode, or "" in flag mode, where
+// chirps are stored as written.
+func (cfg *apiConfig) chirpCensorReplacement() string {
+	if cfg.ProfanityMode != profanityCensor {
+		return ""
+	}
+	return cfg.ProfanityReplacement
+}
+
+// flagProfanity sets ContainsProfanity on chirps when running in flag mode.
+// In censor mode stored bodies are already clean, so nothing is flagged.
+func (cfg *apiConfig) flagProfanity(chirps []Chirp) {
+	if cfg.ProfanityMode != profanityFlag {
+		return
+	}
+
+	for i := range chirps {
+		chirps[i].ContainsProfanity = containsProfanity(chirps[i].Body, cfg.ProfanityMatch)
+	}
+}
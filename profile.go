@@ -0,0 +1,244 @@
+package main
+
+import (
+	"chirpy/internal/auth"
+	"chirpy/internal/database"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+)
+
+// UserStats represents the counts shown in a user's profile header.
+type UserStats struct {
+	UserID     uuid.UUID `json:"user_id"`
+	ChirpCount int64     `json:"chirp_count"`
+}
+
+// maxAvatarURLLength bounds stored avatar URLs.
+const maxAvatarURLLength = 2048
+
+var errInvalidAvatarURL = errors.New("avatar_url must be an http or https URL")
+
+// validateAvatarURL checks that an avatar URL is a well-formed http(s) URL.
+// Other schemes, including data: URLs, are rejected.
+func validateAvatarURL(raw string) error {
+	if len(raw) > maxAvatarURLLength {
+		return errInvalidAvatarURL
+	}
+
+	parsed, err := url.Parse(raw)
+	if err != nil {
+		return errInvalidAvatarURL
+	}
+
+	if (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return errInvalidAvatarURL
+	}
+
+	return nil
+}
+
+// avatarURLParam converts an optional avatar URL from a request body into a query
+// parameter, validating it unless it is the empty string used to clear the avatar.
+func avatarURLParam(avatarURL *string) (sql.NullString, error) {
+	if avatarURL == nil {
+		return sql.NullString{}, nil
+	}
+
+	if *avatarURL != "" {
+		err := validateAvatarURL(*avatarURL)
+		if err != nil {
+			return sql.NullString{}, err
+		}
+	}
+
+	return sql.NullString{String: *avatarURL, Valid: true}, nil
+}
+
+// maxBioLength is the longest bio accepted, counted in characters rather than bytes.
+const maxBioLength = 280
+
+var errBioTooLong = errors.New("bio must be at most 280 characters")
+
+// bioParam converts an optional bio from a request body into a query parameter,
+// enforcing the length cap and masking profanity with replacement as chirps are.
+func bioParam(bio *string, replacement string, match profanityMatch) (sql.NullString, error) {
+	if bio == nil {
+		return sql.NullString{}, nil
+	}
+
+	if utf8.RuneCountInString(*bio) > maxBioLength {
+		return sql.NullString{}, errBioTooLong
+	}
+
+	return sql.NullString{String: sanitizeChirp(*bio, replacement, match), Valid: true}, nil
+}
+
+// getUserStatsHandler returns aggregate counts for a user's profile.
+func (cfg *apiConfig) getUserStatsHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := uuid.Parse(r.PathValue("userID"))
+	if err != nil {
+		respondWithErrorCode(w, http.StatusBadRequest, codeInvalidID, "Invalid user ID")
+		return
+	}
+
+	stats, err := retryRead(r.Context(), func() (database.GetUserStatsRow, error) {
+		return cfg.DB.GetUserStats(r.Context(), userID)
+	})
+	if err != nil {
+		if err == sql.ErrNoRows {
+			respondWithErrorCode(w, http.StatusNotFound, codeUserNotFound, "User not found")
+			return
+		}
+		respondWithErrorCode(w, http.StatusInternalServerError, codeInternalError, "Failed to retrieve user stats")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, UserStats{
+		UserID:     stats.ID,
+		ChirpCount: stats.ChirpCount,
+	})
+}
+
+// bulkGetUsersHandler returns the public profiles for a list of user IDs in
+// one request, so clients rendering chirps by many authors don't fetch each
+// one separately. Unknown IDs are skipped.
+func (cfg *apiConfig) bulkGetUsersHandler(w http.ResponseWriter, r *http.Request) {
+	if !requireJSONContentType(w, r) {
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	var userIDs []uuid.UUID
+
+	err := decoder.Decode(&userIDs)
+	if err != nil {
+		respondWithErrorCode(w, http.StatusBadRequest, codeInvalidPayload, "Request body must be an array of user IDs")
+		return
+	}
+
+	if len(userIDs) > maxBulkGetSize {
+		respondWithErrorCode(w, http.StatusUnprocessableEntity, codeBatchTooLarge, fmt.Sprintf("Cannot fetch more than %d users at once", maxBulkGetSize))
+		return
+	}
+
+	dbUsers, err := retryRead(r.Context(), func() ([]database.User, error) {
+		return cfg.DB.GetUsersByIDs(r.Context(), userIDs)
+	})
+	if err != nil {
+		respondWithErrorCode(w, http.StatusInternalServerError, codeInternalError, "Failed to retrieve users")
+		return
+	}
+
+	// Anyone can call this, so return public profiles without emails
+	users := []PublicUser{}
+	for _, dbUser := range dbUsers {
+		users = append(users, databaseUserToPublicUser(dbUser))
+	}
+
+	respondWithJSON(w, http.StatusOK, users)
+}
+
+// pinChirpBody represents the expected JSON request body for pinning a chirp.
+type pinChirpBody struct {
+	ChirpID uuid.UUID `json:"chirp_id"`
+}
+
+// pinChirpHandler pins one of the authenticated user's own chirps to their profile.
+func (cfg *apiConfig) pinChirpHandler(w http.ResponseWriter, r *http.Request) {
+	tokenString, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithErrorCode(w, http.StatusUnauthorized, codeMissingToken, "Couldn't find JWT")
+		return
+	}
+
+	userID, err := cfg.JWTKeys.ValidateJWT(tokenString)
+	if err != nil {
+		respondWithErrorCode(w, http.StatusUnauthorized, codeInvalidToken, "Invalid JWT")
+		return
+	}
+
+	if !requireJSONContentType(w, r) {
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	var reqBody pinChirpBody
+
+	err = decoder.Decode(&reqBody)
+	if err != nil {
+		respondWithErrorCode(w, http.StatusBadRequest, codeInvalidPayload, "Invalid request payload")
+		return
+	}
+	if reqBody.ChirpID == uuid.Nil {
+		respondWithErrorCode(w, http.StatusUnprocessableEntity, codeInvalidPayload, "Request body must contain a chirp_id")
+		return
+	}
+
+	dbChirp, err := cfg.DB.GetChirpForDeletion(r.Context(), reqBody.ChirpID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			respondWithErrorCode(w, http.StatusNotFound, codeChirpNotFound, "Chirp not found")
+			return
+		}
+		respondWithErrorCode(w, http.StatusInternalServerError, codeInternalError, "Failed to retrieve chirp")
+		return
+	}
+
+	if dbChirp.UserID != userID {
+		respondWithErrorCode(w, http.StatusForbidden, codeForbidden, "You can only pin your own chirps")
+		return
+	}
+
+	updatedUser, err := cfg.DB.SetPinnedChirp(r.Context(), database.SetPinnedChirpParams{
+		ID:            userID,
+		PinnedChirpID: uuid.NullUUID{UUID: dbChirp.ID, Valid: true},
+	})
+	cfg.UserCache.Invalidate(userID)
+	if err != nil {
+		// The JWT may outlive the account it was issued for
+		if err == sql.ErrNoRows {
+			respondWithErrorCode(w, http.StatusUnauthorized, codeUserDeleted, "User no longer exists")
+			return
+		}
+		respondWithErrorCode(w, http.StatusInternalServerError, codeInternalError, "Failed to pin chirp")
+		return
+	}
+
+	w.Header().Set("ETag", userETag(updatedUser.Version))
+	respondWithJSON(w, http.StatusOK, databaseUserToUser(updatedUser))
+}
+
+// unpinChirpHandler clears the authenticated user's pinned chirp, if any.
+func (cfg *apiConfig) unpinChirpHandler(w http.ResponseWriter, r *http.Request) {
+	tokenString, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithErrorCode(w, http.StatusUnauthorized, codeMissingToken, "Couldn't find JWT")
+		return
+	}
+
+	userID, err := cfg.JWTKeys.ValidateJWT(tokenString)
+	if err != nil {
+		respondWithErrorCode(w, http.StatusUnauthorized, codeInvalidToken, "Invalid JWT")
+		return
+	}
+
+	_, err = cfg.DB.ClearPinnedChirp(r.Context(), userID)
+	cfg.UserCache.Invalidate(userID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			respondWithErrorCode(w, http.StatusUnauthorized, codeUserDeleted, "User no longer exists")
+			return
+		}
+		respondWithErrorCode(w, http.StatusInternalServerError, codeInternalError, "Failed to unpin chirp")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
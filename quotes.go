@@ -0,0 +1,120 @@
+package main
+
+import (
+	"chirpy/internal/database"
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// QuotedChirp is the summary of a quoted chirp nested inside the quote.
+type QuotedChirp struct {
+	ID                uuid.UUID                `json:"id"`
+	CreatedAt         time.Time                `json:"created_at"`
+	Body              string                   `json:"body"`
+	UserID            uuid.UUID                `json:"user_id"`
+	Visibility        database.ChirpVisibility `json:"visibility"`
+	Author            *PublicUser              `json:"author,omitempty"`
+	ContainsProfanity bool                     `json:"contains_profanity,omitempty"`
+}
+
+// quotedChirpIDs returns the distinct chirps quoted by chirps.
+func quotedChirpIDs(chirps []Chirp) []uuid.UUID {
+	ids := []uuid.UUID{}
+	seen := map[uuid.UUID]bool{}
+	for _, chirp := range chirps {
+		if chirp.QuotedChirpID != nil && !seen[*chirp.QuotedChirpID] {
+			seen[*chirp.QuotedChirpID] = true
+			ids = append(ids, *chirp.QuotedChirpID)
+		}
+	}
+	return ids
+}
+
+// embedQuotedChirps nests a summary of each quoted chirp, with its author,
+// fetching them all in one query. Quotes of chirps that were deleted, belong
+// to deactivated users, or that the viewer can't see are marked unavailable.
+func (cfg *apiConfig) embedQuotedChirps(ctx context.Context, chirps []Chirp, viewer chirpViewer) error {
+	ids := quotedChirpIDs(chirps)
+	if len(ids) == 0 {
+		return nil
+	}
+
+	dbQuoted, err := retryRead(ctx, func() ([]database.Chirp, error) {
+		return cfg.DB.GetChirpsByIDs(ctx, database.GetChirpsByIDsParams{Ids: ids, ViewerID: viewer.id})
+	})
+	if err != nil {
+		return err
+	}
+
+	quoted := []Chirp{}
+	for _, dbChirp := range dbQuoted {
+		quoted = append(quoted, databaseChirpToChirp(dbChirp))
+	}
+	quoted = visibleChirps(quoted, viewer)
+
+	err = cfg.embedAuthors(ctx, quoted)
+	if err != nil {
+		return err
+	}
+
+	summaries := map[uuid.UUID]*QuotedChirp{}
+	for _, q := range quoted {
+		summaries[q.ID] = &QuotedChirp{
+			ID:                q.ID,
+			CreatedAt:         q.CreatedAt,
+			Body:              q.Body,
+			UserID:            q.UserID,
+			Visibility:        q.Visibility,
+			Author:            q.Author,
+			ContainsProfanity: cfg.ProfanityMode == profanityFlag && containsProfanity(q.Body, cfg.ProfanityMatch),
+		}
+	}
+
+	for i := range chirps {
+		if chirps[i].QuotedChirpID == nil {
+			continue
+		}
+		chirps[i].QuotedChirp = summaries[*chirps[i].QuotedChirpID]
+		chirps[i].QuotedChirpUnavailable = chirps[i].QuotedChirp == nil
+	}
+
+	return nil
+}
+
+// checkQuotedChirps confirms that every chirp in ids exists and is visible to
+// the caller, so new chirps can only quote chirps their author could read.
+// It writes an error response and returns false otherwise.
+func (cfg *apiConfig) checkQuotedChirps(w http.ResponseWriter, r *http.Request, ids []uuid.UUID) bool {
+	if len(ids) == 0 {
+		return true
+	}
+
+	viewer, ok := cfg.requestViewer(w, r)
+	if !ok {
+		return false
+	}
+
+	dbQuoted, err := retryRead(r.Context(), func() ([]database.Chirp, error) {
+		return cfg.DB.GetChirpsByIDs(r.Context(), database.GetChirpsByIDsParams{Ids: ids, ViewerID: viewer.id})
+	})
+	if err != nil {
+		respondWithErrorCode(w, http.StatusInternalServerError, codeInternalError, "Failed to retrieve quoted chirp")
+		return false
+	}
+
+	found := map[uuid.UUID]bool{}
+	for _, dbChirp := range dbQuoted {
+		found[dbChirp.ID] = viewer.canSee(dbChirp.UserID, dbChirp.Visibility)
+	}
+	for _, id := range ids {
+		if !found[id] {
+			respondWithErrorCode(w, http.StatusNotFound, codeChirpNotFound, "Quoted chirp not found")
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"chirpy/internal/database"
+	"context"
+	"database/sql"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// chirpRateLimitWindow is the rolling window the per-user chirp limits apply to.
+const chirpRateLimitWindow = time.Hour
+
+// checkChirpRateLimit reports whether user may post n more chirps right now.
+// When they may not, it also returns how long until a slot frees up.
+func (cfg *apiConfig) checkChirpRateLimit(ctx context.Context, user database.User, n int) (allowed bool, retryAfter time.Duration, err error) {
+	limit := cfg.ChirpRateLimit
+	if user.IsChirpyRed {
+		limit = cfg.ChirpRateLimitRed
+	}
+
+	windowStart := time.Now().UTC().Add(-chirpRateLimitWindow)
+	count, err := cfg.DB.CountChirpsByUserSince(ctx, database.CountChirpsByUserSinceParams{
+		UserID:    user.ID,
+		CreatedAt: windowStart,
+	})
+	if err != nil {
+		return false, 0, err
+	}
+
+	if count+int64(n) <= int64(limit) {
+		return true, 0, nil
+	}
+
+	// The oldest chirp in the window is the next one to age out
+	oldest, err := cfg.DB.GetOldestChirpTimeByUserSince(ctx, database.GetOldestChirpTimeByUserSinceParams{
+		UserID:    user.ID,
+		CreatedAt: windowStart,
+	})
+	if err != nil {
+		// No chirps in the window means the request alone exceeds the limit
+		if err == sql.ErrNoRows {
+			return false, chirpRateLimitWindow, nil
+		}
+		return false, 0, err
+	}
+
+	return false, time.Until(oldest.Add(chirpRateLimitWindow)), nil
+}
+
+// setRetryAfter sets a Retry-After hint in whole seconds, rounding up.
+func setRetryAfter(w http.ResponseWriter, retryAfter time.Duration) {
+	seconds := int(math.Ceil(retryAfter.Seconds()))
+	if seconds < 1 {
+		seconds = 1
+	}
+
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+}
+
+// respondWithRateLimited writes a 429 with a Retry-After hint.
+func respondWithRateLimited(w http.ResponseWriter, retryAfter time.Duration) {
+	setRetryAfter(w, retryAfter)
+	respondWithErrorCode(w, http.StatusTooManyRequests, codeRateLimited, "Chirp rate limit exceeded, try again later")
+}
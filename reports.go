@@ -0,0 +1,150 @@
+package main
+
+import (
+	"chirpy/internal/auth"
+	"chirpy/internal/database"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+)
+
+// maxReportReasonLength caps the optional reason, counted in runes.
+const maxReportReasonLength = 500
+
+// reportChirpBody represents the expected JSON request body for reporting a chirp.
+type reportChirpBody struct {
+	Reason string `json:"reason"`
+}
+
+// ChirpReport is the response for a newly filed report.
+type ChirpReport struct {
+	ChirpID   uuid.UUID `json:"chirp_id"`
+	Reason    string    `json:"reason"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// ReportedChirp is a chirp in the moderation queue with its open report count.
+type ReportedChirp struct {
+	Chirp
+	ReportCount    int64     `json:"report_count"`
+	LastReportedAt time.Time `json:"last_reported_at"`
+}
+
+// reportChirpHandler lets an authenticated user flag a chirp for moderators.
+// Each user can report a given chirp once.
+func (cfg *apiConfig) reportChirpHandler(w http.ResponseWriter, r *http.Request) {
+	tokenString, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithErrorCode(w, http.StatusUnauthorized, codeMissingToken, "Couldn't find JWT")
+		return
+	}
+
+	userID, err := cfg.JWTKeys.ValidateJWT(tokenString)
+	if err != nil {
+		respondWithErrorCode(w, http.StatusUnauthorized, codeInvalidToken, "Invalid JWT")
+		return
+	}
+
+	chirpID, err := uuid.Parse(r.PathValue("chirpID"))
+	if err != nil {
+		respondWithErrorCode(w, http.StatusBadRequest, codeInvalidID, "Invalid chirp ID")
+		return
+	}
+
+	if !requireJSONContentType(w, r) {
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	var reqBody reportChirpBody
+
+	err = decoder.Decode(&reqBody)
+	if err != nil {
+		respondWithErrorCode(w, http.StatusBadRequest, codeInvalidPayload, "Invalid request payload")
+		return
+	}
+
+	reason := strings.TrimSpace(reqBody.Reason)
+	if utf8.RuneCountInString(reason) > maxReportReasonLength {
+		respondWithErrorCode(w, http.StatusUnprocessableEntity, codeInvalidPayload, "Reason is too long")
+		return
+	}
+
+	_, err = cfg.DB.GetChirpForDeletion(r.Context(), chirpID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			respondWithErrorCode(w, http.StatusNotFound, codeChirpNotFound, "Chirp not found")
+			return
+		}
+		respondWithErrorCode(w, http.StatusInternalServerError, codeInternalError, "Failed to retrieve chirp")
+		return
+	}
+
+	now := time.Now().UTC()
+	inserted, err := cfg.DB.CreateChirpReport(r.Context(), database.CreateChirpReportParams{
+		ChirpID:    chirpID,
+		ReporterID: userID,
+		Reason:     reason,
+		CreatedAt:  now,
+	})
+	if err != nil {
+		respondWithErrorCode(w, http.StatusInternalServerError, codeInternalError, "Failed to report chirp")
+		return
+	}
+	if inserted == 0 {
+		respondWithErrorCode(w, http.StatusConflict, codeAlreadyReported, "You have already reported this chirp")
+		return
+	}
+
+	respondWithJSON(w, http.StatusCreated, ChirpReport{
+		ChirpID:   chirpID,
+		Reason:    reason,
+		CreatedAt: now,
+	})
+}
+
+// adminReportsHandler lists chirps with open reports, most reported first.
+func (cfg *apiConfig) adminReportsHandler(w http.ResponseWriter, r *http.Request) {
+	err := cfg.authorizeAdmin(r.Header)
+	if err != nil {
+		respondWithErrorCode(w, http.StatusUnauthorized, codeInvalidAPIKey, "Invalid or missing admin API key")
+		return
+	}
+
+	limit, offset, err := parsePagination(r)
+	if err != nil {
+		respondWithErrorCode(w, http.StatusBadRequest, codeInvalidParameter, err.Error())
+		return
+	}
+
+	rows, err := cfg.DB.GetReportedChirps(r.Context(), database.GetReportedChirpsParams{
+		Limit:  limit,
+		Offset: offset,
+	})
+	if err != nil {
+		respondWithErrorCode(w, http.StatusInternalServerError, codeInternalError, "Failed to retrieve reports")
+		return
+	}
+
+	reported := []ReportedChirp{}
+	for _, row := range rows {
+		reported = append(reported, ReportedChirp{
+			Chirp: Chirp{
+				ID:        row.ID,
+				CreatedAt: row.CreatedAt,
+				UpdatedAt: row.UpdatedAt,
+				Body:      row.Body,
+				UserID:    row.UserID,
+			},
+			ReportCount:    row.ReportCount,
+			LastReportedAt: row.LastReportedAt,
+		})
+	}
+
+	respondWithJSON(w, http.StatusOK, reported)
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"net"
+	"syscall"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+const (
+	// maxReadRetries is how many times an idempotent read is retried after a
+	// transient database error.
+	maxReadRetries = 2
+	// readRetryBackoff is the delay before the first retry; it doubles each time.
+	readRetryBackoff = 50 * time.Millisecond
+)
+
+// isTransientDBError reports whether err looks like a lost or refused database
+// connection, as opposed to an error the query itself caused.
+func isTransientDBError(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	if errors.Is(err, driver.ErrBadConn) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, syscall.ECONNRESET) {
+		return true
+	}
+
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		// Class 08 is connection exceptions; 57P01-57P03 are server shutdown
+		// and startup, which is what a Postgres restart looks like
+		switch pqErr.Code {
+		case "57P01", "57P02", "57P03":
+			return true
+		}
+		return pqErr.Code.Class() == "08"
+	}
+
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
+
+// retryRead runs an idempotent read, retrying with backoff when it fails with a
+// transient database error. Only use it for queries that are safe to repeat.
+func retryRead[T any](ctx context.Context, read func() (T, error)) (T, error) {
+	backoff := readRetryBackoff
+
+	result, err := read()
+	for attempt := 0; attempt < maxReadRetries && isTransientDBError(err); attempt++ {
+		select {
+		case <-ctx.Done():
+			return result, err
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+
+		result, err = read()
+	}
+
+	return result, err
+}
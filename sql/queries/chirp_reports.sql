@@ -0,0 +1,22 @@
+-- name: CreateChirpReport :execrows
+-- Affects no rows when the user already reported this chirp.
+INSERT INTO chirp_reports (chirp_id, reporter_id, reason, created_at)
+VALUES ($1, $2, $3, $4)
+ON CONFLICT (chirp_id, reporter_id) DO NOTHING;
+
+-- name: GetReportedChirps :many
+SELECT chirps.id, chirps.created_at, chirps.updated_at, chirps.body, chirps.user_id,
+    COUNT(*) AS report_count,
+    MAX(chirp_reports.created_at)::timestamp AS last_reported_at
+FROM chirp_reports
+JOIN chirps ON chirps.id = chirp_reports.chirp_id
+WHERE chirp_reports.archived_at IS NULL
+GROUP BY chirps.id
+ORDER BY report_count DESC, last_reported_at DESC
+LIMIT $1 OFFSET $2;
+
+-- name: ArchiveChirpReports :exec
+UPDATE chirp_reports
+SET archived_at = NOW()
+WHERE chirp_id = $1
+    AND archived_at IS NULL;
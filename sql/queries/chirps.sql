@@ -1,21 +1,44 @@
 -- name: CreateChirp :one
-INSERT INTO chirps (id, created_at, updated_at, body, user_id)
-VALUES ($1, $2, $3, $4, $5)
+INSERT INTO chirps (id, created_at, updated_at, body, user_id, quoted_chirp_id, visibility)
+VALUES ($1, $2, $3, $4, $5, $6, $7)
 RETURNING *;
 
 -- name: DeleteChirps :exec
 DELETE FROM chirps;
 
 -- name: GetChirps :many
+-- Public listings skip chirps by deactivated users. Private chirps are only
+-- returned to their author, the viewer; a NULL viewer_id sees public ones.
 SELECT * FROM chirps
+WHERE user_id IN (SELECT id FROM users WHERE active)
+    AND (visibility = 'public' OR user_id = sqlc.narg(viewer_id))
 ORDER BY created_at ASC;
 
 -- name: GetChirp :one
-SELECT * FROM chirps WHERE id = $1;
+SELECT * FROM chirps
+WHERE id = @id AND user_id IN (SELECT id FROM users WHERE active)
+    AND (visibility = 'public' OR user_id = sqlc.narg(viewer_id));
+
+-- name: GetLatestChirp :one
+-- Backed by the created_at index, so it reads one row however big the table is.
+SELECT * FROM chirps
+WHERE user_id IN (SELECT id FROM users WHERE active)
+    AND NOT (user_id = ANY(@excluded_user_ids::uuid[]))
+    AND (visibility = 'public' OR user_id = sqlc.narg(viewer_id))
+ORDER BY created_at DESC, id DESC
+LIMIT 1;
+
+-- name: GetChirpsByIDs :many
+SELECT * FROM chirps
+WHERE id = ANY(@ids::uuid[])
+    AND user_id IN (SELECT id FROM users WHERE active)
+    AND (visibility = 'public' OR user_id = sqlc.narg(viewer_id))
+ORDER BY created_at ASC;
 
 -- name: GetChirpsByAuthorID :many
 SELECT * FROM chirps
-WHERE user_id = $1
+WHERE user_id = @user_id AND user_id IN (SELECT id FROM users WHERE active)
+    AND (visibility = 'public' OR user_id = sqlc.narg(viewer_id))
 ORDER BY created_at ASC;
 
 -- name: GetChirpForDeletion :one
@@ -23,3 +46,99 @@ SELECT id, user_id FROM chirps WHERE id = $1;
 
 -- name: DeleteChirp :exec
 DELETE FROM chirps WHERE id = $1 AND user_id = $2;
+
+-- name: DeleteChirpByID :exec
+DELETE FROM chirps WHERE id = $1;
+
+-- name: GetChirpsOrdered :many
+SELECT * FROM chirps
+WHERE user_id IN (SELECT id FROM users WHERE active)
+    AND (visibility = 'public' OR user_id = sqlc.narg(viewer_id))
+ORDER BY
+    CASE WHEN @sort_desc::boolean THEN created_at END DESC,
+    CASE WHEN NOT @sort_desc::boolean THEN created_at END ASC;
+
+-- name: GetChirpsByAuthorIDOrdered :many
+SELECT * FROM chirps
+WHERE user_id = @user_id AND user_id IN (SELECT id FROM users WHERE active)
+    AND (visibility = 'public' OR user_id = sqlc.narg(viewer_id))
+ORDER BY
+    CASE WHEN @sort_desc::boolean THEN created_at END DESC,
+    CASE WHEN NOT @sort_desc::boolean THEN created_at END ASC;
+
+-- name: CountChirps :one
+-- Totals skip chirps by deactivated users, as listings do.
+SELECT COUNT(*) FROM chirps
+WHERE user_id IN (SELECT id FROM users WHERE active);
+
+-- name: CountChirpsSince :one
+SELECT COUNT(*) FROM chirps
+WHERE created_at > $1
+    AND user_id IN (SELECT id FROM users WHERE active);
+
+-- name: CountChirpsByUserSince :one
+SELECT COUNT(*) FROM chirps
+WHERE user_id = $1
+    AND created_at > $2;
+
+-- name: GetOldestChirpTimeByUserSince :one
+SELECT created_at FROM chirps
+WHERE user_id = $1
+    AND created_at > $2
+ORDER BY created_at ASC
+LIMIT 1;
+
+-- name: GetChirpsPage :many
+-- Keyset pagination: the cursor is the (created_at, id) of the last chirp
+-- already seen, with id breaking ties between chirps created together.
+-- The optional since position keeps only chirps newer than it whatever the
+-- sort order, and a NULL page_limit returns every matching chirp. Chirps by
+-- deactivated users are skipped unless include_inactive is set. user_ids
+-- narrows to any of several authors, for list timelines. Excluded users and
+-- other users' private chirps are filtered here rather than after the LIMIT,
+-- so pages still come back full; include_private keeps every private chirp.
+SELECT * FROM chirps
+WHERE (sqlc.narg(user_id)::uuid IS NULL OR user_id = sqlc.narg(user_id))
+    AND (sqlc.narg(user_ids)::uuid[] IS NULL OR user_id = ANY(sqlc.narg(user_ids)::uuid[]))
+    AND (@include_inactive::boolean OR user_id IN (SELECT id FROM users WHERE active))
+    AND NOT (user_id = ANY(@excluded_user_ids::uuid[]))
+    AND (@include_private::boolean OR visibility = 'public' OR user_id = sqlc.narg(viewer_id))
+    AND (
+        sqlc.narg(cursor_created_at)::timestamp IS NULL
+        OR (@sort_desc::boolean AND (created_at, id) < (sqlc.narg(cursor_created_at)::timestamp, sqlc.narg(cursor_id)::uuid))
+        OR (NOT @sort_desc::boolean AND (created_at, id) > (sqlc.narg(cursor_created_at)::timestamp, sqlc.narg(cursor_id)::uuid))
+    )
+    AND (
+        sqlc.narg(since_created_at)::timestamp IS NULL
+        OR (created_at, id) > (sqlc.narg(since_created_at)::timestamp, sqlc.narg(since_id)::uuid)
+    )
+ORDER BY
+    CASE WHEN @sort_desc::boolean THEN created_at END DESC,
+    CASE WHEN @sort_desc::boolean THEN id END DESC,
+    CASE WHEN NOT @sort_desc::boolean THEN created_at END ASC,
+    CASE WHEN NOT @sort_desc::boolean THEN id END ASC
+LIMIT sqlc.narg(page_limit);
+
+-- name: GetChirpActivity :many
+-- Days with no chirps are omitted; callers zero-fill them.
+SELECT date_trunc('day', created_at)::timestamp AS day, COUNT(*) AS chirp_count
+FROM chirps
+WHERE created_at >= @from_time AND created_at < @to_time
+    AND (sqlc.narg(user_id)::uuid IS NULL OR user_id = sqlc.narg(user_id))
+    AND user_id IN (SELECT id FROM users WHERE active)
+GROUP BY day
+ORDER BY day;
+
+-- name: CountChirpsMatching :one
+-- Counts what GET /api/chirps lists for the same author and since filters
+-- without reading the rows. Chirps by deactivated or excluded users, and
+-- private chirps not written by the viewer, are skipped.
+SELECT COUNT(*) FROM chirps
+WHERE (sqlc.narg(user_ids)::uuid[] IS NULL OR user_id = ANY(sqlc.narg(user_ids)::uuid[]))
+    AND user_id IN (SELECT id FROM users WHERE active)
+    AND NOT (user_id = ANY(@excluded_user_ids::uuid[]))
+    AND (visibility = 'public' OR user_id = sqlc.narg(viewer_id))
+    AND (
+        sqlc.narg(since_created_at)::timestamp IS NULL
+        OR (created_at, id) > (sqlc.narg(since_created_at)::timestamp, sqlc.narg(since_id)::uuid)
+    );
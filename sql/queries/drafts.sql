@@ -0,0 +1,24 @@
+-- name: CreateDraft :one
+INSERT INTO drafts (id, created_at, updated_at, body, user_id)
+VALUES ($1, $2, $3, $4, $5)
+RETURNING *;
+
+-- name: GetDraftsByUser :many
+SELECT * FROM drafts
+WHERE user_id = $1
+ORDER BY updated_at DESC, id DESC;
+
+-- name: GetDraft :one
+-- Scoped to the owner so other users' drafts look like they don't exist.
+SELECT * FROM drafts
+WHERE id = $1 AND user_id = $2;
+
+-- name: UpdateDraft :one
+UPDATE drafts
+SET body = $3, updated_at = $4
+WHERE id = $1 AND user_id = $2
+RETURNING *;
+
+-- name: DeleteDraft :execrows
+DELETE FROM drafts
+WHERE id = $1 AND user_id = $2;
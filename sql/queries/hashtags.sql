@@ -0,0 +1,24 @@
+-- name: CreateChirpHashtag :exec
+INSERT INTO chirp_hashtags (chirp_id, tag)
+VALUES ($1, $2)
+ON CONFLICT DO NOTHING;
+
+-- name: GetChirpsByHashtag :many
+SELECT chirps.* FROM chirps
+JOIN chirp_hashtags ON chirps.id = chirp_hashtags.chirp_id
+WHERE chirp_hashtags.tag = @tag
+    AND chirps.user_id IN (SELECT id FROM users WHERE active)
+    AND (chirps.visibility = 'public' OR chirps.user_id = sqlc.narg(viewer_id))
+ORDER BY chirps.created_at ASC;
+
+-- name: GetTrendingHashtags :many
+-- Trends are the same for everyone, so only public chirps count.
+SELECT chirp_hashtags.tag, COUNT(*) AS chirp_count
+FROM chirp_hashtags
+JOIN chirps ON chirps.id = chirp_hashtags.chirp_id
+WHERE chirps.created_at > $1
+    AND chirps.user_id IN (SELECT id FROM users WHERE active)
+    AND chirps.visibility = 'public'
+GROUP BY chirp_hashtags.tag
+ORDER BY chirp_count DESC, chirp_hashtags.tag ASC
+LIMIT $2;
@@ -0,0 +1,16 @@
+-- name: GetIdempotentChirp :one
+SELECT chirps.* FROM idempotency_keys
+JOIN chirps ON chirps.id = idempotency_keys.chirp_id
+WHERE idempotency_keys.user_id = $1
+    AND idempotency_keys.key = $2
+    AND idempotency_keys.created_at > $3;
+
+-- name: CreateIdempotencyKey :execrows
+INSERT INTO idempotency_keys (user_id, key, chirp_id, created_at)
+VALUES (@user_id, @key, @chirp_id, @created_at)
+ON CONFLICT (user_id, key) DO UPDATE
+SET chirp_id = EXCLUDED.chirp_id, created_at = EXCLUDED.created_at
+WHERE idempotency_keys.created_at <= @expired_before::timestamp;
+
+-- name: DeleteExpiredIdempotencyKeys :execrows
+DELETE FROM idempotency_keys WHERE created_at <= $1;
@@ -0,0 +1,23 @@
+-- name: GetLoginFailure :one
+SELECT * FROM login_failures WHERE email = $1;
+
+-- name: RecordFailedLogin :one
+INSERT INTO login_failures (email, failed_attempts, updated_at)
+VALUES ($1, 1, $2)
+ON CONFLICT (email) DO UPDATE
+SET failed_attempts = login_failures.failed_attempts + 1, updated_at = $2
+RETURNING *;
+
+-- name: LockLogin :exec
+-- Starts the count over so the email gets a fresh set of attempts once the lock ends.
+UPDATE login_failures
+SET failed_attempts = 0, locked_until = $2, updated_at = $3
+WHERE email = $1;
+
+-- name: ClearLoginFailures :exec
+DELETE FROM login_failures WHERE email = $1;
+
+-- name: DeleteStaleLoginFailures :execrows
+DELETE FROM login_failures
+WHERE updated_at <= $1
+    AND (locked_until IS NULL OR locked_until <= $1);
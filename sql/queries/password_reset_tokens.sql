@@ -0,0 +1,12 @@
+-- name: ConsumePasswordResetToken :one
+UPDATE password_reset_tokens
+SET used_at = NOW(), updated_at = NOW()
+WHERE token = $1
+    AND expires_at > NOW()
+    AND used_at IS NULL
+RETURNING *;
+
+-- name: CreatePasswordResetToken :one
+INSERT INTO password_reset_tokens (token, created_at, updated_at, user_id, expires_at)
+VALUES ($1, $2, $3, $4, $5)
+RETURNING *;
@@ -1,6 +1,6 @@
 -- name: CreateRefreshToken :one
-INSERT INTO refresh_tokens (token, created_at, updated_at, user_id, expires_at)
-VALUES ($1, $2, $3, $4, $5)
+INSERT INTO refresh_tokens (token, created_at, updated_at, user_id, expires_at, family_id)
+VALUES ($1, $2, $3, $4, $5, $6)
 RETURNING *;
 
 -- name: DeleteRefreshTokens :exec
@@ -17,3 +17,34 @@ WHERE refresh_tokens.token = $1
 UPDATE refresh_tokens
 SET revoked_at = NOW(), updated_at = NOW()
 WHERE token = $1;
+
+-- name: RevokeRefreshTokensForUser :exec
+UPDATE refresh_tokens
+SET revoked_at = NOW(), updated_at = NOW()
+WHERE user_id = $1
+    AND revoked_at IS NULL;
+
+-- name: CountActiveRefreshTokensForTokenOwner :one
+-- Counts the live sessions of whoever owns the given token; unknown tokens count zero.
+SELECT COUNT(*) FROM refresh_tokens
+WHERE user_id = (SELECT rt.user_id FROM refresh_tokens rt WHERE rt.token = $1)
+    AND expires_at > NOW()
+    AND revoked_at IS NULL;
+
+-- name: RotateRefreshToken :one
+-- Retires a live token so it can be exchanged exactly once.
+UPDATE refresh_tokens
+SET revoked_at = NOW(), rotated_at = NOW(), updated_at = NOW()
+WHERE token = $1
+    AND expires_at > NOW()
+    AND revoked_at IS NULL
+RETURNING *;
+
+-- name: GetRefreshToken :one
+SELECT * FROM refresh_tokens WHERE token = $1;
+
+-- name: RevokeRefreshTokenFamily :exec
+UPDATE refresh_tokens
+SET revoked_at = NOW(), updated_at = NOW()
+WHERE family_id = $1
+    AND revoked_at IS NULL;
@@ -0,0 +1,14 @@
+-- name: CreateUserBlock :exec
+INSERT INTO user_blocks (blocker_id, blocked_id, created_at)
+VALUES ($1, $2, $3)
+ON CONFLICT (blocker_id, blocked_id) DO NOTHING;
+
+-- name: DeleteUserBlock :execrows
+DELETE FROM user_blocks
+WHERE blocker_id = $1 AND blocked_id = $2;
+
+-- name: GetBlockRelatedUserIDs :many
+-- Users hidden from the given user: those they blocked and those who blocked them.
+SELECT blocked_id AS user_id FROM user_blocks WHERE user_blocks.blocker_id = $1
+UNION
+SELECT blocker_id AS user_id FROM user_blocks WHERE user_blocks.blocked_id = $1;
@@ -1,9 +1,9 @@
 -- name: CreateUser :one
-INSERT INTO users (id, created_at, updated_at, email, hashed_password)
-VALUES ($1, $2, $3, $4, $5)
+INSERT INTO users (id, created_at, updated_at, email, hashed_password, avatar_url, bio)
+VALUES ($1, $2, $3, $4, $5, $6, $7)
 RETURNING *;
 
--- name: DeleteUsers :exec
+-- name: DeleteUsers :execrows
 DELETE FROM users;
 
 -- name: GetUserByEmail :one
@@ -11,12 +11,93 @@ SELECT * FROM users WHERE email = $1;
 
 -- name: UpdateUser :one
 UPDATE users
-SET email = $2, hashed_password = $3, updated_at = $4
-WHERE id = $1
+SET email = @email,
+    hashed_password = @hashed_password,
+    updated_at = @updated_at,
+    -- A NULL avatar_url keeps the current value; an empty string clears it
+    avatar_url = CASE
+        WHEN sqlc.narg(avatar_url)::text IS NULL THEN avatar_url
+        ELSE NULLIF(sqlc.narg(avatar_url)::text, '')
+    END,
+    bio = COALESCE(sqlc.narg(bio)::text, bio),
+    version = version + 1
+WHERE id = @id
+    AND (sqlc.narg(expected_version)::integer IS NULL OR version = sqlc.narg(expected_version))
 RETURNING *;
 
 -- name: UpdateUserIsChirpyRed :one
 UPDATE users
-SET is_chirpy_red = TRUE, updated_at = NOW()
+SET is_chirpy_red = TRUE, updated_at = NOW(), version = version + 1
+WHERE id = $1
+RETURNING *;
+
+-- name: UpdateUserIsChirpyRedFalse :one
+UPDATE users
+SET is_chirpy_red = FALSE, updated_at = NOW(), version = version + 1
+WHERE id = $1
+RETURNING *;
+
+-- name: UpdateUserPassword :one
+UPDATE users
+SET hashed_password = $2, updated_at = $3, version = version + 1
+WHERE id = $1
+RETURNING *;
+
+-- name: GetUsersPaginated :many
+SELECT * FROM users
+ORDER BY created_at ASC
+LIMIT $1 OFFSET $2;
+
+-- name: CountUsers :one
+SELECT COUNT(*) FROM users;
+
+-- name: GetUserByID :one
+SELECT * FROM users WHERE id = $1;
+
+-- name: DeleteUser :execrows
+DELETE FROM users WHERE id = $1;
+
+-- name: GetUsersByIDs :many
+SELECT * FROM users WHERE id = ANY(@ids::uuid[]);
+
+-- name: GetUserStats :one
+-- Returns no row for an unknown user, so callers can tell them apart from users with no chirps.
+-- Chirps by deactivated users aren't counted, so a deactivated user shows zero.
+SELECT users.id, (
+    SELECT COUNT(*) FROM chirps
+    WHERE chirps.user_id = users.id
+        AND chirps.user_id IN (SELECT id FROM users WHERE active)
+) AS chirp_count
+FROM users
+WHERE users.id = $1;
+
+-- name: SetPinnedChirp :one
+UPDATE users
+SET pinned_chirp_id = $2, updated_at = NOW(), version = version + 1
+WHERE id = $1
+RETURNING *;
+
+-- name: ClearPinnedChirp :one
+UPDATE users
+SET pinned_chirp_id = NULL, updated_at = NOW(), version = version + 1
+WHERE id = $1
+RETURNING *;
+
+-- name: UnpinDeletedChirp :exec
+-- Run before deleting a chirp. ON DELETE SET NULL would clear the pin too,
+-- but without bumping version, leaving the author's ETag stale.
+UPDATE users
+SET pinned_chirp_id = NULL, updated_at = NOW(), version = version + 1
+WHERE pinned_chirp_id = $1;
+
+-- name: SetUserActive :one
+UPDATE users
+SET active = $2, updated_at = NOW(), version = version + 1
+WHERE id = $1
+RETURNING *;
+
+-- name: SetUserVerified :one
+UPDATE users
+SET is_verified = $2, updated_at = NOW(), version = version + 1
 WHERE id = $1
 RETURNING *;
@@ -0,0 +1,8 @@
+-- name: CreateWebhookEvent :execrows
+-- Affects no rows when the event was already processed.
+INSERT INTO webhook_events (event_id, processed_at)
+VALUES ($1, $2)
+ON CONFLICT (event_id) DO NOTHING;
+
+-- name: DeleteWebhookEventsBefore :execrows
+DELETE FROM webhook_events WHERE processed_at <= $1;
@@ -0,0 +1,8 @@
+-- +goose Up
+CREATE TABLE webhook_events (
+    event_id TEXT PRIMARY KEY,
+    processed_at TIMESTAMP NOT NULL
+);
+
+-- +goose Down
+DROP TABLE webhook_events;
@@ -0,0 +1,6 @@
+-- +goose Up
+-- Deleting the pinned chirp clears the pin rather than blocking the delete
+ALTER TABLE users ADD COLUMN pinned_chirp_id UUID REFERENCES chirps(id) ON DELETE SET NULL;
+
+-- +goose Down
+ALTER TABLE users DROP COLUMN pinned_chirp_id;
@@ -0,0 +1,14 @@
+-- +goose Up
+-- chirp_id has no foreign key so reports outlive the chirp; deleting a chirp
+-- archives its reports instead.
+CREATE TABLE chirp_reports (
+    chirp_id UUID NOT NULL,
+    reporter_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    reason TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMP NOT NULL,
+    archived_at TIMESTAMP,
+    PRIMARY KEY (chirp_id, reporter_id)
+);
+
+-- +goose Down
+DROP TABLE chirp_reports;
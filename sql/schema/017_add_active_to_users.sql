@@ -0,0 +1,7 @@
+-- +goose Up
+-- Deactivated accounts keep their data but can't log in, and their chirps
+-- are hidden from public listings until they reactivate
+ALTER TABLE users ADD COLUMN active BOOLEAN NOT NULL DEFAULT TRUE;
+
+-- +goose Down
+ALTER TABLE users DROP COLUMN active;
@@ -0,0 +1,14 @@
+-- +goose Up
+CREATE TABLE user_blocks (
+    blocker_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    blocked_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    created_at TIMESTAMP NOT NULL,
+    PRIMARY KEY (blocker_id, blocked_id),
+    CHECK (blocker_id <> blocked_id)
+);
+
+-- Finds who has blocked a given user
+CREATE INDEX idx_user_blocks_blocked_id ON user_blocks (blocked_id);
+
+-- +goose Down
+DROP TABLE user_blocks;
@@ -0,0 +1,14 @@
+-- +goose Up
+CREATE TABLE drafts (
+    id UUID PRIMARY KEY,
+    created_at TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP NOT NULL,
+    body TEXT NOT NULL,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE
+);
+
+-- Lists a user's drafts, most recently edited first
+CREATE INDEX idx_drafts_user_id_updated_at ON drafts (user_id, updated_at DESC);
+
+-- +goose Down
+DROP TABLE drafts;
@@ -0,0 +1,7 @@
+-- +goose Up
+-- quoted_chirp_id has no foreign key so the quote survives the quoted chirp
+-- being deleted; readers show it as unavailable instead.
+ALTER TABLE chirps ADD COLUMN quoted_chirp_id UUID CHECK (quoted_chirp_id <> id);
+
+-- +goose Down
+ALTER TABLE chirps DROP COLUMN quoted_chirp_id;
@@ -0,0 +1,7 @@
+-- +goose Up
+-- is_verified marks official accounts. It is set by admins only and is
+-- unrelated to is_chirpy_red, which billing controls.
+ALTER TABLE users ADD COLUMN is_verified BOOLEAN NOT NULL DEFAULT FALSE;
+
+-- +goose Down
+ALTER TABLE users DROP COLUMN is_verified;
@@ -0,0 +1,12 @@
+-- +goose Up
+-- Keyed by the email tried rather than the user, so unknown emails are
+-- counted and locked like real ones and lockouts don't reveal which exist.
+CREATE TABLE login_failures (
+    email TEXT PRIMARY KEY,
+    failed_attempts INTEGER NOT NULL DEFAULT 0,
+    locked_until TIMESTAMP,
+    updated_at TIMESTAMP NOT NULL
+);
+
+-- +goose Down
+DROP TABLE login_failures;
@@ -0,0 +1,9 @@
+-- +goose Up
+-- Private chirps are only shown to their author. A followers-only level is
+-- planned once users can follow each other, and will be added to the enum.
+CREATE TYPE chirp_visibility AS ENUM ('public', 'private');
+ALTER TABLE chirps ADD COLUMN visibility chirp_visibility NOT NULL DEFAULT 'public';
+
+-- +goose Down
+ALTER TABLE chirps DROP COLUMN visibility;
+DROP TYPE chirp_visibility;
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// platformStatsTTL is how long GET /api/stats serves a cached result before recounting.
+const platformStatsTTL = 60 * time.Second
+
+// PlatformStats are the public totals shown on the landing page.
+type PlatformStats struct {
+	TotalUsers    int64 `json:"total_users"`
+	TotalChirps   int64 `json:"total_chirps"`
+	ChirpsLast24h int64 `json:"chirps_last_24h"`
+}
+
+// platformStatsCache keeps the last computed PlatformStats. It refreshes
+// lazily: the first request after expiry recounts while holding the lock, so
+// concurrent requests wait for that one query set instead of each running it.
+type platformStatsCache struct {
+	mu        sync.Mutex
+	stats     PlatformStats
+	expiresAt time.Time
+}
+
+// get returns the cached stats, calling load to refresh them once they've
+// expired. If the refresh fails, previously cached stats are served instead.
+func (c *platformStatsCache) get(ctx context.Context, load func(context.Context) (PlatformStats, error)) (PlatformStats, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	if now.Before(c.expiresAt) {
+		return c.stats, nil
+	}
+
+	stats, err := load(ctx)
+	if err != nil {
+		if c.expiresAt.IsZero() {
+			return PlatformStats{}, err
+		}
+		slog.Warn("Failed to refresh platform stats; serving stale values", "error", err)
+		return c.stats, nil
+	}
+
+	c.stats = stats
+	c.expiresAt = now.Add(platformStatsTTL)
+	return stats, nil
+}
+
+// loadPlatformStats counts users and chirps in the database.
+func (cfg *apiConfig) loadPlatformStats(ctx context.Context) (PlatformStats, error) {
+	var stats PlatformStats
+	var err error
+
+	stats.TotalUsers, err = cfg.DB.CountUsers(ctx)
+	if err != nil {
+		return PlatformStats{}, err
+	}
+	stats.TotalChirps, err = cfg.DB.CountChirps(ctx)
+	if err != nil {
+		return PlatformStats{}, err
+	}
+	stats.ChirpsLast24h, err = cfg.DB.CountChirpsSince(ctx, time.Now().UTC().Add(-24*time.Hour))
+	if err != nil {
+		return PlatformStats{}, err
+	}
+
+	return stats, nil
+}
+
+// getPlatformStatsHandler returns public platform totals, cached for platformStatsTTL.
+func (cfg *apiConfig) getPlatformStatsHandler(w http.ResponseWriter, r *http.Request) {
+	stats, err := cfg.PlatformStats.get(r.Context(), cfg.loadPlatformStats)
+	if err != nil {
+		respondWithErrorCode(w, http.StatusInternalServerError, codeInternalError, "Failed to retrieve stats")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, stats)
+}
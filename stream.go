@@ -0,0 +1,155 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	// subscriberBufferSize is how many chirps may queue for a slow subscriber before
+	// further chirps are dropped for it.
+	subscriberBufferSize = 16
+	// streamHeartbeatInterval keeps proxies from closing idle event streams.
+	streamHeartbeatInterval = 15 * time.Second
+)
+
+// chirpBroker fans newly created chirps out to in-process subscribers.
+type chirpBroker struct {
+	mu          sync.Mutex
+	subscribers map[chan Chirp]struct{}
+}
+
+// newChirpBroker returns a broker with no subscribers.
+func newChirpBroker() *chirpBroker {
+	return &chirpBroker{
+		subscribers: map[chan Chirp]struct{}{},
+	}
+}
+
+// Subscribe registers a new subscriber channel. Callers must Unsubscribe when done.
+func (b *chirpBroker) Subscribe() chan Chirp {
+	ch := make(chan Chirp, subscriberBufferSize)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.subscribers[ch] = struct{}{}
+
+	return ch
+}
+
+// Unsubscribe removes a subscriber and closes its channel.
+func (b *chirpBroker) Unsubscribe(ch chan Chirp) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if _, ok := b.subscribers[ch]; ok {
+		delete(b.subscribers, ch)
+		close(ch)
+	}
+}
+
+// Publish sends a chirp to every subscriber without blocking the caller.
+func (b *chirpBroker) Publish(chirp Chirp) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for ch := range b.subscribers {
+		select {
+		case ch <- chirp:
+		default:
+			// Subscriber is too far behind; drop rather than stall chirp creation
+		}
+	}
+}
+
+// streamChirpsHandler pushes newly created chirps to the client as server-sent events.
+func (cfg *apiConfig) streamChirpsHandler(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		respondWithErrorCode(w, http.StatusInternalServerError, codeInternalError, "Streaming is not supported")
+		return
+	}
+
+	// An optional bearer token shows the viewer's private chirps and hides
+	// chirps across their blocks, as listings do. It's read once, when the
+	// subscription starts.
+	viewer, ok := cfg.requestViewer(w, r)
+	if !ok {
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	chirps := cfg.ChirpBroker.Subscribe()
+	defer cfg.ChirpBroker.Unsubscribe(chirps)
+
+	heartbeat := time.NewTicker(streamHeartbeatInterval)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			// Client disconnected
+			return
+		case <-heartbeat.C:
+			// SSE comment lines are ignored by clients but keep the connection warm
+			fmt.Fprint(w, ": heartbeat\n\n")
+			flusher.Flush()
+		case chirp := <-chirps:
+			chirp, visible := cfg.feedChirp(r.Context(), chirp, viewer)
+			if !visible {
+				continue
+			}
+			dat, err := json.Marshal(chirp)
+			if err != nil {
+				continue
+			}
+			fmt.Fprintf(w, "event: chirp\ndata: %s\n\n", dat)
+			flusher.Flush()
+		}
+	}
+}
+
+// publishChirp notifies live feed subscribers of a new chirp, and the
+// external webhook too if anyone may read it.
+func (cfg *apiConfig) publishChirp(ctx context.Context, chirp Chirp) {
+	cfg.ChirpBroker.Publish(chirp)
+
+	// The webhook has no viewer, so it gets what an anonymous feed would
+	if cfg.ChirpWebhook == nil {
+		return
+	}
+	if chirp, visible := cfg.feedChirp(ctx, chirp, chirpViewer{}); visible {
+		cfg.ChirpWebhook.Notify(chirp, "chirp_id", chirp.ID)
+	}
+}
+
+// feedChirp prepares a published chirp for one live feed subscriber. It
+// returns false when the subscriber shouldn't see the chirp at all: it's
+// private or a block hides its author from them, or the author has since
+// been deactivated. A quote of a chirp they can't see is marked unavailable,
+// as in listings.
+func (cfg *apiConfig) feedChirp(ctx context.Context, chirp Chirp, viewer chirpViewer) (Chirp, bool) {
+	if !viewer.canSee(chirp.UserID, chirp.Visibility) {
+		return Chirp{}, false
+	}
+
+	author, err := cfg.cachedUserByID(ctx, chirp.UserID)
+	if err != nil || !author.Active {
+		return Chirp{}, false
+	}
+
+	if chirp.QuotedChirp != nil && !viewer.canSee(chirp.QuotedChirp.UserID, chirp.QuotedChirp.Visibility) {
+		chirp.QuotedChirp = nil
+		chirp.QuotedChirpUnavailable = true
+	}
+	return chirp, true
+}
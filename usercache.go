@@ -0,0 +1,158 @@
+package main
+
+import (
+	"chirpy/internal/database"
+	"container/list"
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// userCache is a size-bounded LRU of users by ID whose entries also expire
+// after a TTL. It only backs read paths that render users, such as chirp
+// authors; anything that authenticates or authorizes still reads the database.
+// A nil cache is valid and caches nothing.
+type userCache struct {
+	mu       sync.Mutex
+	capacity int
+	ttl      time.Duration
+	// order holds *userCacheEntry values, most recently used at the front
+	order   *list.List
+	entries map[uuid.UUID]*list.Element
+	// generation bumps on every invalidation so a lookup that raced with a
+	// write doesn't store the row it read before the write
+	generation uint64
+
+	hits   atomic.Int64
+	misses atomic.Int64
+}
+
+type userCacheEntry struct {
+	user      database.User
+	expiresAt time.Time
+}
+
+// newUserCache returns a cache holding up to capacity users for ttl each.
+func newUserCache(capacity int, ttl time.Duration) *userCache {
+	return &userCache{
+		capacity: capacity,
+		ttl:      ttl,
+		order:    list.New(),
+		entries:  map[uuid.UUID]*list.Element{},
+	}
+}
+
+// get returns the cached user and the current generation, which add needs
+// when the caller goes on to load the user itself.
+func (c *userCache) get(id uuid.UUID) (database.User, uint64, bool) {
+	if c == nil {
+		return database.User{}, 0, false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.entries[id]
+	if !ok {
+		c.misses.Add(1)
+		return database.User{}, c.generation, false
+	}
+
+	entry := elem.Value.(*userCacheEntry)
+	if time.Now().After(entry.expiresAt) {
+		c.order.Remove(elem)
+		delete(c.entries, id)
+		c.misses.Add(1)
+		return database.User{}, c.generation, false
+	}
+
+	c.order.MoveToFront(elem)
+	c.hits.Add(1)
+	return entry.user, c.generation, true
+}
+
+// add stores user unless the cache was invalidated since generation was read.
+func (c *userCache) add(user database.User, generation uint64) {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if generation != c.generation {
+		return
+	}
+
+	entry := &userCacheEntry{user: user, expiresAt: time.Now().Add(c.ttl)}
+	if elem, ok := c.entries[user.ID]; ok {
+		elem.Value = entry
+		c.order.MoveToFront(elem)
+		return
+	}
+
+	c.entries[user.ID] = c.order.PushFront(entry)
+	if c.order.Len() > c.capacity {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*userCacheEntry).user.ID)
+	}
+}
+
+// Invalidate drops the cached copy of a user after it changes or is deleted.
+func (c *userCache) Invalidate(id uuid.UUID) {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.generation++
+	if elem, ok := c.entries[id]; ok {
+		c.order.Remove(elem)
+		delete(c.entries, id)
+	}
+}
+
+// Clear drops every cached user, for bulk deletes.
+func (c *userCache) Clear() {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.generation++
+	c.order.Init()
+	c.entries = map[uuid.UUID]*list.Element{}
+}
+
+// Stats returns the hit and miss counts since startup.
+func (c *userCache) Stats() (hits, misses int64) {
+	if c == nil {
+		return 0, 0
+	}
+	return c.hits.Load(), c.misses.Load()
+}
+
+// cachedUserByID looks a user up through the user cache, falling back to the
+// database on a miss. Only use it where a slightly stale user is acceptable.
+func (cfg *apiConfig) cachedUserByID(ctx context.Context, id uuid.UUID) (database.User, error) {
+	user, generation, ok := cfg.UserCache.get(id)
+	if ok {
+		return user, nil
+	}
+
+	user, err := cfg.DB.GetUserByID(ctx, id)
+	if err != nil {
+		return database.User{}, err
+	}
+
+	cfg.UserCache.add(user, generation)
+	return user, nil
+}
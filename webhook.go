@@ -2,31 +2,66 @@ package main
 
 import (
 	"chirpy/internal/auth"
+	"chirpy/internal/database"
+	"context"
 	"database/sql"
 	"encoding/json"
-	"log"
+	"errors"
+	"log/slog"
 	"net/http"
+	"time"
 
 	"github.com/google/uuid"
 )
 
+const (
+	// webhookMaxAge is how old an event may be before it's refused as a possible replay.
+	webhookMaxAge = 5 * time.Minute
+	// webhookEventTTL is how long processed event IDs are remembered. It only
+	// needs to outlast webhookMaxAge, since older events are refused anyway.
+	webhookEventTTL = 24 * time.Hour
+)
+
+// errDuplicateWebhookEvent means the event ID was already processed.
+var errDuplicateWebhookEvent = errors.New("webhook event already processed")
+
 type webhookBody struct {
-	Event string `json:"event"`
-	Data  struct {
+	// EventID and Timestamp let us drop replayed and duplicate deliveries
+	EventID   string    `json:"event_id"`
+	Timestamp time.Time `json:"timestamp"`
+	Event     string    `json:"event"`
+	Data      struct {
 		UserID string `json:"user_id"`
 	} `json:"data"`
 }
 
+// chirpyRedForEvent reports the Chirpy Red status a Polka event sets, and
+// whether the event is one we act on at all.
+func chirpyRedForEvent(event string) (isChirpyRed, handled bool) {
+	switch event {
+	case "user.upgraded":
+		return true, true
+	case "user.downgraded":
+		return false, true
+	default:
+		return false, false
+	}
+}
+
 func (cfg *apiConfig) webhookHandler(w http.ResponseWriter, r *http.Request) {
 	// 1. Get and validate the API key
 	apiKey, err := auth.GetAPIKey(r.Header)
 	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Couldn't find API key")
+		respondWithErrorCode(w, http.StatusUnauthorized, codeInvalidAPIKey, "Couldn't find API key")
 		return
 	}
 
 	if apiKey != cfg.PolkaKey {
-		respondWithError(w, http.StatusUnauthorized, "Invalid API key")
+		respondWithErrorCode(w, http.StatusUnauthorized, codeInvalidAPIKey, "Invalid API key")
+		return
+	}
+
+	if !requireJSONContentType(w, r) {
 		return
 	}
 
@@ -35,33 +70,101 @@ func (cfg *apiConfig) webhookHandler(w http.ResponseWriter, r *http.Request) {
 
 	err = decoder.Decode(&reqBody)
 	if err != nil {
-		log.Printf("Error decoding webhook body: %v", err)
-		w.WriteHeader(http.StatusBadRequest)
+		slog.Warn("Error decoding webhook body", "request_id", requestIDFromContext(r.Context()), "error", err)
+		respondWithErrorCode(w, http.StatusBadRequest, codeInvalidPayload, "Webhook body must be a JSON object: "+err.Error())
+		return
+	}
+
+	if reqBody.EventID == "" || reqBody.Timestamp.IsZero() {
+		slog.Warn("Webhook missing event_id or timestamp", "request_id", requestIDFromContext(r.Context()))
+		respondWithErrorCode(w, http.StatusBadRequest, codeInvalidPayload, "event_id and timestamp are required")
 		return
 	}
 
-	if reqBody.Event != "user.upgraded" {
+	if reqBody.Event == "" {
+		respondWithErrorCode(w, http.StatusBadRequest, codeInvalidPayload, "event is required")
+		return
+	}
+
+	// Refuse stale events so a captured request can't be replayed later.
+	// Events from the future are allowed the same leeway for clock skew.
+	age := time.Since(reqBody.Timestamp)
+	if age > webhookMaxAge {
+		slog.Warn("Rejected stale webhook", "request_id", requestIDFromContext(r.Context()), "event_id", reqBody.EventID, "age", age.String())
+		respondWithErrorCode(w, http.StatusGone, codeWebhookExpired, "Event timestamp is too old")
+		return
+	}
+	if age < -webhookMaxAge {
+		slog.Warn("Rejected webhook from the future", "request_id", requestIDFromContext(r.Context()), "event_id", reqBody.EventID, "age", age.String())
+		respondWithErrorCode(w, http.StatusBadRequest, codeInvalidPayload, "Event timestamp is in the future")
+		return
+	}
+
+	// Well-formed events we don't act on still get a 2xx, or Polka would keep retrying them
+	isChirpyRed, handled := chirpyRedForEvent(reqBody.Event)
+	if !handled {
 		w.WriteHeader(http.StatusNoContent)
 		return
 	}
 
 	userID, err := uuid.Parse(reqBody.Data.UserID)
 	if err != nil {
-		log.Printf("Invalid user ID in webhook: %v", err)
-		w.WriteHeader(http.StatusBadRequest)
+		slog.Warn("Invalid user ID in webhook", "request_id", requestIDFromContext(r.Context()), "error", err)
+		respondWithErrorCode(w, http.StatusBadRequest, codeInvalidID, "data.user_id must be a valid UUID")
 		return
 	}
 
-	_, err = cfg.DB.UpdateUserIsChirpyRed(r.Context(), userID)
+	// Recording the event ID in the same transaction means a failed update
+	// leaves the event free to be retried
+	err = cfg.withTx(r.Context(), func(q *database.Queries) error {
+		recorded, err := q.CreateWebhookEvent(r.Context(), database.CreateWebhookEventParams{
+			EventID:     reqBody.EventID,
+			ProcessedAt: time.Now().UTC(),
+		})
+		if err != nil {
+			return err
+		}
+		if recorded == 0 {
+			return errDuplicateWebhookEvent
+		}
+
+		if isChirpyRed {
+			_, err = q.UpdateUserIsChirpyRed(r.Context(), userID)
+		} else {
+			_, err = q.UpdateUserIsChirpyRedFalse(r.Context(), userID)
+		}
+		return err
+	})
+	cfg.UserCache.Invalidate(userID)
 	if err != nil {
+		// A 2xx tells the sender to stop redelivering an event we've already applied
+		if err == errDuplicateWebhookEvent {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
 		if err == sql.ErrNoRows {
-			w.WriteHeader(http.StatusNotFound)
+			respondWithErrorCode(w, http.StatusNotFound, codeUserNotFound, "User not found")
 			return
 		}
-		log.Printf("Failed to update user to Chirpy Red: %v", err)
-		w.WriteHeader(http.StatusInternalServerError)
+		slog.Error("Failed to update Chirpy Red status", "request_id", requestIDFromContext(r.Context()), "user_id", userID, "is_chirpy_red", isChirpyRed, "error", err)
+		respondWithErrorCode(w, http.StatusInternalServerError, codeInternalError, "Failed to update user")
 		return
 	}
 
 	w.WriteHeader(http.StatusNoContent)
 }
+
+// cleanupWebhookEvents periodically forgets event IDs older than webhookEventTTL.
+func (cfg *apiConfig) cleanupWebhookEvents(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		deleted, err := cfg.DB.DeleteWebhookEventsBefore(context.Background(), time.Now().UTC().Add(-webhookEventTTL))
+		if err != nil {
+			slog.Error("Failed to delete old webhook events", "error", err)
+			continue
+		}
+		slog.Debug("Deleted old webhook events", "deleted", deleted)
+	}
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+)
+
+const (
+	// wsWriteWait is how long a single frame write may take.
+	wsWriteWait = 10 * time.Second
+	// wsPongWait is how long we wait for a pong before treating the client as gone.
+	wsPongWait = 60 * time.Second
+	// wsPingPeriod must be shorter than wsPongWait so pings arrive before the deadline.
+	wsPingPeriod = (wsPongWait * 9) / 10
+	// wsMaxMessageSize bounds inbound frames; clients only send control frames for now.
+	wsMaxMessageSize = 512
+)
+
+// newWSUpgrader returns an upgrader that accepts handshakes from the browser
+// origins cors allows, so allowlisted frontends can connect cross-origin.
+func newWSUpgrader(cors *corsPolicy) *websocket.Upgrader {
+	return &websocket.Upgrader{
+		ReadBufferSize:  1024,
+		WriteBufferSize: 1024,
+		CheckOrigin:     cors.checkWebSocketOrigin,
+	}
+}
+
+// websocketHandler upgrades the connection and streams newly created chirps as JSON frames.
+func (cfg *apiConfig) websocketHandler(w http.ResponseWriter, r *http.Request) {
+	// Browsers can't set Authorization on the handshake, so the JWT comes in the query
+	tokenString := r.URL.Query().Get("token")
+	if tokenString == "" {
+		respondWithErrorCode(w, http.StatusUnauthorized, codeMissingToken, "Couldn't find JWT")
+		return
+	}
+
+	viewerID, err := cfg.JWTKeys.ValidateJWT(tokenString)
+	if err != nil {
+		respondWithErrorCode(w, http.StatusUnauthorized, codeInvalidToken, "Invalid JWT")
+		return
+	}
+
+	// Blocks are read once, when the subscription starts
+	hidden, err := cfg.blockRelatedUsers(r.Context(), viewerID)
+	if err != nil {
+		respondWithErrorCode(w, http.StatusInternalServerError, codeInternalError, "Failed to retrieve blocked users")
+		return
+	}
+	viewer := chirpViewer{id: uuid.NullUUID{UUID: viewerID, Valid: true}, hidden: hidden}
+
+	// Upgrade writes its own HTTP error response on failure
+	conn, err := newWSUpgrader(cfg.CORS).Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	chirps := cfg.ChirpBroker.Subscribe()
+	defer cfg.ChirpBroker.Unsubscribe(chirps)
+
+	// The read loop handles pongs and notices when the client goes away
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+
+		conn.SetReadLimit(wsMaxMessageSize)
+		conn.SetReadDeadline(time.Now().Add(wsPongWait))
+		conn.SetPongHandler(func(string) error {
+			conn.SetReadDeadline(time.Now().Add(wsPongWait))
+			return nil
+		})
+
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	ping := time.NewTicker(wsPingPeriod)
+	defer ping.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case chirp := <-chirps:
+			chirp, visible := cfg.feedChirp(r.Context(), chirp, viewer)
+			if !visible {
+				continue
+			}
+			conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+			if err := conn.WriteJSON(chirp); err != nil {
+				return
+			}
+		case <-ping.C:
+			conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+			if err := conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				return
+			}
+		}
+	}
+}